@@ -0,0 +1,106 @@
+// Package capture records raw RCON sessions to disk so they can be inspected or replayed later.
+//
+// A capture file is line oriented, one RCON line per record. Each record is made of four
+// tab separated fields:
+//
+//	<timestamp>\t<direction>\t<connection id>\t<line>
+//
+// timestamp is RFC 3339 with nanoseconds, direction is either `in` (game server to us) or
+// `out` (us to game server), and connection id is the ID assigned by the game server or `-`
+// before we've been given one. line is the raw RCON line, including its type byte and trailing
+// newline, quoted with Go string syntax so delimiters and control characters survive intact.
+//
+// The password sent in the `a` authentication line is always replaced by Redacted.
+package capture
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redacted replaces sensitive values in a capture
+const Redacted = "<redacted>"
+
+// Direction a line traveled in
+type Direction string
+
+const (
+	// Inbound is a line sent by the game server
+	Inbound Direction = "in"
+
+	// Outbound is a line we sent to the game server
+	Outbound Direction = "out"
+)
+
+// noConnectionID is written when the game server hasn't assigned us an ID yet
+const noConnectionID = "-"
+
+// Record is a single line of an RCON session
+type Record struct {
+	Time         time.Time
+	Direction    Direction
+	ConnectionID string
+	Line         string
+}
+
+func (s Record) String() string {
+	connID := s.ConnectionID
+	if connID == "" {
+		connID = noConnectionID
+	}
+
+	return strings.Join([]string{
+		s.Time.UTC().Format(time.RFC3339Nano),
+		string(s.Direction),
+		connID,
+		strconv.Quote(s.Line),
+	}, "\t")
+}
+
+// ParseRecord decodes a single line of a capture file
+func ParseRecord(input string) (Record, error) {
+	var rec Record
+
+	parts := strings.SplitN(strings.TrimRight(input, "\r\n"), "\t", 4)
+	if len(parts) != 4 {
+		return rec, fmt.Errorf("capture: expected 4 fields got %d", len(parts))
+	}
+
+	_ = parts[3] // Bounds check elimination
+
+	ts, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return rec, fmt.Errorf("capture: invalid timestamp %s: %w", parts[0], err)
+	}
+
+	switch dir := Direction(parts[1]); dir {
+	case Inbound, Outbound:
+		rec.Direction = dir
+	default:
+		return rec, fmt.Errorf("capture: unknown direction %s", parts[1])
+	}
+
+	line, err := strconv.Unquote(parts[3])
+	if err != nil {
+		return rec, fmt.Errorf("capture: invalid line %s: %w", parts[3], err)
+	}
+
+	rec.Time = ts
+	rec.Line = line
+	if parts[2] != noConnectionID {
+		rec.ConnectionID = parts[2]
+	}
+
+	return rec, nil
+}
+
+// redact strips secrets from outbound lines before they're written
+func redact(rec Record) Record {
+	if rec.Direction == Outbound && strings.HasPrefix(rec.Line, "a") {
+		rec.Line = "a" + Redacted + "\n"
+	}
+
+	return rec
+}
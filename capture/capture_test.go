@@ -0,0 +1,75 @@
+package capture_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tankbusta/renx-rcon/capture"
+)
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cap")
+
+	w, err := capture.NewWriter(path, capture.Options{})
+	require.NoError(t, err)
+
+	now := time.Now()
+	records := []capture.Record{
+		{Time: now, Direction: capture.Outbound, Line: "ahunter2\n"},
+		{Time: now, Direction: capture.Inbound, ConnectionID: "1234", Line: "lCHAT\x02Say;\x02hi\tthere\n"},
+	}
+
+	for _, rec := range records {
+		require.NoError(t, w.Write(rec))
+	}
+	require.NoError(t, w.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	r := capture.NewReader(f)
+
+	rec, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "a"+capture.Redacted+"\n", rec.Line, "password must be redacted")
+	require.Empty(t, rec.ConnectionID)
+
+	rec, err = r.Next()
+	require.NoError(t, err)
+	require.Equal(t, records[1].Line, rec.Line)
+	require.Equal(t, "1234", rec.ConnectionID)
+	require.True(t, now.Equal(rec.Time))
+
+	_, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cap")
+
+	w, err := capture.NewWriter(path, capture.Options{MaxBytes: 100, MaxBackups: 2})
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, w.Write(capture.Record{
+			Time:      time.Now(),
+			Direction: capture.Inbound,
+			Line:      "lGAME\x02Spawn;\x02player\n",
+		}))
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		require.NoError(t, err)
+		require.LessOrEqual(t, info.Size(), int64(100))
+	}
+
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
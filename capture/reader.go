@@ -0,0 +1,46 @@
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Reader decodes records from a capture file
+type Reader struct {
+	// unexported fields below
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next record, or io.EOF once the capture is exhausted
+func (s *Reader) Next() (Record, error) {
+	for s.scanner.Scan() {
+		s.line++
+
+		text := s.scanner.Text()
+		if text == "" {
+			continue
+		}
+
+		rec, err := ParseRecord(text)
+		if err != nil {
+			return rec, fmt.Errorf("line %d: %w", s.line, err)
+		}
+
+		return rec, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+
+	return Record{}, io.EOF
+}
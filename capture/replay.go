@@ -0,0 +1,189 @@
+package capture
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEndOfReplay is returned by a replay connection once every inbound line has been played back
+var ErrEndOfReplay = errors.New("capture: end of replay")
+
+// ReplayOptions controls how a capture is played back
+type ReplayOptions struct {
+	// RealTime waits between lines as long as the original session did.
+	// Otherwise lines are played back as fast as they can be consumed
+	RealTime bool
+
+	// Speed scales the delays when RealTime is set, 2 plays back twice as fast. Defaults to 1
+	Speed float64
+
+	// Command is called with every command line the original session sent. If it returns nil,
+	// playback pauses until the same line is written to the connection so the responses that
+	// follow reach the command that asked for them. Without it, written lines are discarded
+	Command func(line string) error
+}
+
+// replayConn is a net.Conn that reads the inbound side of a capture and, unless a recorded
+// command is being waited on, discards everything written to it
+type replayConn struct {
+	rdr  *Reader
+	opts ReplayOptions
+
+	// pending is the remainder of the line being read
+	pending []byte
+	last    time.Time
+
+	// expected is the recorded command line we're waiting to be written, written fires once it was
+	expected string
+	written  chan struct{}
+	mu       sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReplayConn returns a connection that plays back the inbound lines of a capture
+// as if they were sent by a live game server
+func NewReplayConn(r *Reader, opts ReplayOptions) net.Conn {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+
+	return &replayConn{
+		rdr:     r,
+		opts:    opts,
+		written: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (s *replayConn) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		rec, err := s.nextInbound()
+		if err != nil {
+			return 0, err
+		}
+
+		s.pending = []byte(rec.Line)
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+
+	return n, nil
+}
+
+// nextInbound waits for, and returns, the next line the game server sent
+func (s *replayConn) nextInbound() (Record, error) {
+	for {
+		rec, err := s.rdr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return rec, ErrEndOfReplay
+			}
+
+			return rec, err
+		}
+
+		if err := s.pace(rec.Time); err != nil {
+			return rec, err
+		}
+
+		if rec.Direction == Inbound {
+			return rec, nil
+		}
+
+		if err := s.replayCommand(rec.Line); err != nil {
+			return rec, err
+		}
+	}
+}
+
+// replayCommand hands a recorded command line to ReplayOptions.Command and waits for it to be written
+func (s *replayConn) replayCommand(line string) error {
+	if s.opts.Command == nil || !strings.HasPrefix(line, "c") {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.expected = line
+	s.mu.Unlock()
+
+	if err := s.opts.Command(line); err != nil {
+		s.mu.Lock()
+		s.expected = ""
+		s.mu.Unlock()
+
+		return nil // Nothing is going to write it, its responses go to whoever is in flight
+	}
+
+	select {
+	case <-s.closed:
+		return net.ErrClosed
+	case <-s.written:
+		return nil
+	}
+}
+
+// pace sleeps for the time between the previous record and one sent at ts
+func (s *replayConn) pace(ts time.Time) error {
+	defer func() { s.last = ts }()
+
+	if !s.opts.RealTime || s.last.IsZero() || !ts.After(s.last) {
+		select {
+		case <-s.closed:
+			return net.ErrClosed
+		default:
+			return nil
+		}
+	}
+
+	timer := time.NewTimer(time.Duration(float64(ts.Sub(s.last)) / s.opts.Speed))
+	defer timer.Stop()
+
+	select {
+	case <-s.closed:
+		return net.ErrClosed
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Write discards p, the original responses are already part of the capture.
+// It resumes playback if p is the recorded command being waited on
+func (s *replayConn) Write(p []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	s.mu.Lock()
+	if s.expected != "" && string(p) == s.expected {
+		s.expected = ""
+		s.written <- struct{}{}
+	}
+	s.mu.Unlock()
+
+	return len(p), nil
+}
+
+func (s *replayConn) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *replayConn) LocalAddr() net.Addr                { return replayAddr{} }
+func (s *replayConn) RemoteAddr() net.Addr               { return replayAddr{} }
+func (s *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (s *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (s *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
@@ -0,0 +1,100 @@
+package capture_test
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tankbusta/renx-rcon/capture"
+)
+
+// captureOf returns the capture file made of records
+func captureOf(records ...capture.Record) *capture.Reader {
+	var b strings.Builder
+	for _, rec := range records {
+		b.WriteString(rec.String() + "\n")
+	}
+
+	return capture.NewReader(strings.NewReader(b.String()))
+}
+
+func TestReplayConnPacing(t *testing.T) {
+	start := time.Now()
+	rdr := func() *capture.Reader {
+		return captureOf(
+			capture.Record{Time: start, Direction: capture.Inbound, Line: "v3\n"},
+			capture.Record{Time: start.Add(100 * time.Millisecond), Direction: capture.Outbound, Line: "ahunter2\n"},
+			capture.Record{Time: start.Add(400 * time.Millisecond), Direction: capture.Inbound, Line: "a1\n"},
+		)
+	}
+
+	readAll := func(opts capture.ReplayOptions) ([]string, time.Duration) {
+		conn := capture.NewReplayConn(rdr(), opts)
+		defer conn.Close()
+
+		began := time.Now()
+		br := bufio.NewReader(conn)
+
+		var lines []string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				require.ErrorIs(t, err, capture.ErrEndOfReplay)
+				return lines, time.Since(began)
+			}
+
+			lines = append(lines, line)
+		}
+	}
+
+	lines, elapsed := readAll(capture.ReplayOptions{})
+	require.Equal(t, []string{"v3\n", "a1\n"}, lines, "only inbound lines are played back")
+	require.Less(t, elapsed, 100*time.Millisecond)
+
+	lines, elapsed = readAll(capture.ReplayOptions{RealTime: true, Speed: 2})
+	require.Equal(t, []string{"v3\n", "a1\n"}, lines)
+	require.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+	require.Less(t, elapsed, 400*time.Millisecond)
+}
+
+func TestReplayConnCommands(t *testing.T) {
+	now := time.Now()
+
+	commands := make(chan string, 1)
+	conn := capture.NewReplayConn(captureOf(
+		capture.Record{Time: now, Direction: capture.Outbound, Line: "cping\n"},
+		capture.Record{Time: now, Direction: capture.Inbound, Line: "rpong\n"},
+	), capture.ReplayOptions{Command: func(line string) error {
+		commands <- line
+		return nil
+	}})
+	defer conn.Close()
+
+	read := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		read <- line
+	}()
+
+	require.Equal(t, "cping\n", <-commands)
+
+	// Playback waits for the recorded command, anything else written is discarded
+	_, err := conn.Write([]byte("s\n"))
+	require.NoError(t, err)
+
+	select {
+	case line := <-read:
+		t.Fatalf("read %q before the command was written", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = conn.Write([]byte("cping\n"))
+	require.NoError(t, err)
+	require.Equal(t, "rpong\n", <-read)
+
+	require.NoError(t, conn.Close())
+	_, err = conn.Write([]byte("cping\n"))
+	require.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Options controls how a capture file is rotated
+type Options struct {
+	// MaxBytes is the size a capture file may grow to before it's rotated. Zero disables rotation
+	MaxBytes int64
+
+	// MaxBackups is how many rotated files are kept as path.1, path.2, ...
+	// Zero keeps a single backup
+	MaxBackups int
+}
+
+// Writer appends records to a capture file, rotating it once it grows past Options.MaxBytes.
+// It's safe for concurrent use
+type Writer struct {
+	// unexported fields below
+	path string
+	opts Options
+	f    *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+// NewWriter opens, or creates, the capture file at path
+func NewWriter(path string, opts Options) (*Writer, error) {
+	if opts.MaxBytes < 0 || opts.MaxBackups < 0 {
+		return nil, fmt.Errorf("capture: MaxBytes and MaxBackups must not be negative")
+	}
+
+	if opts.MaxBackups == 0 {
+		opts.MaxBackups = 1
+	}
+
+	w := &Writer{path: path, opts: opts}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (s *Writer) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("capture: failed to open %s: %w", s.path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("capture: failed to stat %s: %w", s.path, err)
+	}
+
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+// Write appends rec to the capture file
+func (s *Writer) Write(rec Record) error {
+	line := redact(rec).String() + "\n"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return os.ErrClosed
+	}
+
+	if s.opts.MaxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.opts.MaxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.f.WriteString(line)
+	s.size += int64(n)
+
+	return err
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, and starts a fresh file
+func (s *Writer) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return fmt.Errorf("capture: failed to close %s: %w", s.path, err)
+	}
+	s.f = nil
+
+	for i := s.opts.MaxBackups - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", s.path, i)
+		if _, err := os.Stat(from); err == nil {
+			os.Rename(from, fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+	}
+
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return fmt.Errorf("capture: failed to rotate %s: %w", s.path, err)
+	}
+
+	return s.open()
+}
+
+// Close flushes and closes the capture file
+func (s *Writer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+
+	err := s.f.Close()
+	s.f = nil
+
+	return err
+}
@@ -3,17 +3,44 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 
 	rcon "github.com/tankbusta/renx-rcon"
+	"github.com/tankbusta/renx-rcon/capture"
 )
 
 func main() {
 	server := os.Getenv("GAME_SERVER_ADDRESS")
-	rconPassword := os.Getenv("GAME_SERVER_RCON_PASSWORD")
+	credentials := rcon.EnvPassword("GAME_SERVER_RCON_PASSWORD")
+	if passwordFile := os.Getenv("GAME_SERVER_RCON_PASSWORD_FILE"); passwordFile != "" {
+		credentials = rcon.FilePassword(passwordFile)
+	}
+	replayFile := os.Getenv("GAME_SERVER_REPLAY")
+
+	logger := rcon.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	var (
+		svr *rcon.Server
+		err error
+	)
+
+	if replayFile != "" {
+		f, ferr := os.Open(replayFile)
+		if ferr != nil {
+			log.Fatal(ferr)
+		}
+		defer f.Close()
 
-	svr := rcon.NewServer(rconPassword, server)
+		svr, err = rcon.NewReplayServer(f, capture.ReplayOptions{RealTime: os.Getenv("GAME_SERVER_REPLAY_REALTIME") != ""}, logger)
+	} else {
+		svr, err = rcon.NewServer("", server, logger, rcon.WithCredentials(credentials))
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
@@ -12,6 +12,9 @@ import (
 
 type HandleCommandResp func(cmd ICommand, resp string)
 
+// HandleCommandDone is called once a command finished, err is nil if the game server executed it
+type HandleCommandDone func(cmd ICommand, err error)
+
 type ICommand interface {
 	Command() string
 	MarshalRCON() []byte
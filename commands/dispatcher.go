@@ -2,35 +2,250 @@ package commands
 
 import (
 	"container/list"
+	"context"
 	"strings"
 	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type Dispatcher struct {
 	// unexported fields below
 	current *item
 	queue   *list.List
-	mu      sync.RWMutex
+	notify  chan struct{}
+	metrics Metrics
+	tracer  trace.Tracer
+	attrs   []attribute.KeyValue
+
+	// limit bounds the queue, policy decides what happens once it's full
+	limit  int
+	policy QueuePolicy
+	freed  chan struct{}
+
+	// closed is the error new commands fail with once Close was called
+	closed  error
+	drained chan struct{}
+
+	// timeout applies to every command without an entry in timeouts
+	timeout  time.Duration
+	timeouts map[string]time.Duration
+
+	// callbacks are the done callbacks to run once the lock is released, see unlock
+	callbacks []func()
+
+	mu sync.RWMutex
 }
 
 type item struct {
-	cmd  ICommand
-	cb   HandleCommandResp
-	seen int
+	cmd    ICommand
+	cb     HandleCommandResp
+	onDone HandleCommandDone
+	seen   int
+
+	// elem is our position in the queue, nil once we've been handed to Next
+	elem *list.Element
+
+	// sentAt is when Next handed the command out to be written
+	sentAt time.Time
+
+	// written is set once the command went out, only then may the game server answer it
+	written bool
+
+	// err is the error the game server answered with, the command fails with it once finished
+	err error
+
+	// span covers the command from being queued until it finished
+	span trace.Span
+
+	// resp and done are only used by Submit to collect the full response
+	resp Response
+	done chan struct{}
+
+	// stop releases the context and timeout watching the command
+	stop     func()
+	finished bool
+}
+
+// rows returns how many rows were received, not counting the header
+func (s *item) rows() int {
+	if s.cmd.SkipFirstMsg() && s.seen > 0 {
+		return s.seen - 1
+	}
+
+	return s.seen
+}
+
+// end records the outcome on the span and wakes up anyone waiting on the command.
+// Only the first call has any effect
+func (s *item) end(err error) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+
+	if s.stop != nil {
+		s.stop()
+	}
+
+	s.span.SetAttributes(attribute.Int("rcon.command.rows", s.rows()))
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.AddEvent("finished")
+	s.span.End()
+
+	if s.done != nil {
+		s.resp.Err = err
+		close(s.done)
+	}
+}
+
+// Response is everything the game server sent back for a single command
+type Response struct {
+	Command ICommand
+
+	// Header is the first CommandResponse line for commands that SkipFirstMsg
+	Header string
+
+	// Rows are the remaining CommandResponse lines, without the trailing newline
+	Rows []string
+
+	// Err is set when the game server rejected the command or it never completed
+	Err error
+}
+
+// Pending is a command submitted to the Dispatcher that hasn't finished yet
+type Pending struct {
+	it *item
+	d  *Dispatcher
+}
+
+// Done is closed once the game server finished executing the command
+func (s *Pending) Done() <-chan struct{} { return s.it.done }
+
+// Response returns the collected response. It's only complete once Done is closed
+func (s *Pending) Response() Response {
+	s.d.mu.RLock()
+	defer s.d.mu.RUnlock()
+
+	return s.it.resp
+}
+
+// Cancel removes the command from the queue if it hasn't been written yet.
+// It returns false if the command is already in flight or finished
+func (s *Pending) Cancel() bool {
+	s.d.mu.Lock()
+	defer s.d.unlock()
+
+	if s.it.elem == nil {
+		return false
+	}
+
+	s.d.queue.Remove(s.it.elem)
+	s.it.elem = nil
+	s.d.release()
+
+	s.d.end(s.it, context.Canceled)
+	s.d.checkDrained()
+	return true
 }
 
 func NewDispatcher() *Dispatcher {
 	return &Dispatcher{
-		queue: list.New(),
+		queue:   list.New(),
+		notify:  make(chan struct{}, 1),
+		metrics: noopMetrics{},
+		tracer:  noop.NewTracerProvider().Tracer(""),
+		freed:   make(chan struct{}),
 	}
 }
 
+// SetMetrics reports command measurements to m
+func (s *Dispatcher) SetMetrics(m Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m == nil {
+		m = noopMetrics{}
+	}
+
+	s.metrics = m
+}
+
+// SetTimeout fails every command that hasn't finished d after being queued with ErrCommandTimeout,
+// unless SetCommandTimeout says otherwise. Zero, the default, lets commands wait forever
+func (s *Dispatcher) SetTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timeout = d
+}
+
+// SetCommandTimeout overrides the timeout set by SetTimeout for the command named name.
+// Zero lets it wait forever
+func (s *Dispatcher) SetCommandTimeout(name string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timeouts == nil {
+		s.timeouts = make(map[string]time.Duration)
+	}
+
+	s.timeouts[strings.ToLower(name)] = d
+}
+
+func (s *Dispatcher) timeoutFor(cmd ICommand) time.Duration {
+	if d, ok := s.timeouts[strings.ToLower(cmd.Command())]; ok {
+		return d
+	}
+
+	return s.timeout
+}
+
+// SetQueueLimit bounds the number of commands waiting to be written to size, policy
+// decides what happens to new commands once it's full. A size of zero removes the bound
+func (s *Dispatcher) SetQueueLimit(size int, policy QueuePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limit = size
+	s.policy = policy
+	s.release()
+}
+
+// HasNext reports if Next would return a command
+func (s *Dispatcher) HasNext() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current == nil && s.queue.Len() > 0
+}
+
+// SetTracer creates a span with tracer for every command, attrs are added to each of them
+func (s *Dispatcher) SetTracer(tracer trace.Tracer, attrs ...attribute.KeyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("")
+	}
+
+	s.tracer = tracer
+	s.attrs = attrs
+}
+
 func (s *Dispatcher) Next() ICommand {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Only return the next command if we're not processing one
-	if s.current == nil && s.current != nil {
+	if s.current != nil {
 		return nil
 	}
 
@@ -38,42 +253,365 @@ func (s *Dispatcher) Next() ICommand {
 		cmd := elem.Value.(*item)
 		s.current = cmd
 		s.queue.Remove(elem)
+		s.release()
+		cmd.elem = nil
+		cmd.sentAt = time.Now()
+		cmd.span.AddEvent("dequeued")
+
+		s.metrics.CommandSent(cmd.cmd.Command())
 		return cmd.cmd
 	}
 
 	return nil
 }
 
-func (s *Dispatcher) CommandDone() {
+// CommandWritten records that the in flight command was written to the game server
+func (s *Dispatcher) CommandWritten() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil {
+		s.current.written = true
+		s.current.span.AddEvent("written")
+	}
+}
+
+// CommandError records that the game server answered the in flight command with err.
+// The command keeps going until CommandDone, which then fails it with err.
+//
+// It returns false if no command was written yet, err then belongs to something else
+func (s *Dispatcher) CommandError(err error) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	current := s.current
+	if current == nil || !current.written {
+		return false
+	}
+
+	if current.err == nil {
+		current.err = err
+		current.span.AddEvent("error", trace.WithAttributes(attribute.String("rcon.error", err.Error())))
+	}
+
+	return true
+}
+
+// CommandDone marks the in flight command as finished, with the error given to CommandError if any.
+// It's ignored until the command was written, the game server can't have finished it before that
+func (s *Dispatcher) CommandDone() {
+	s.finish(nil, true)
+}
+
+// CommandFailed marks the in flight command as finished with err, without waiting for the game server
+func (s *Dispatcher) CommandFailed(err error) {
+	s.finish(err, false)
+}
+
+// finish ends the in flight command with err. With written set, only if it was written already
+func (s *Dispatcher) finish(err error, written bool) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	current := s.current
+	if current == nil || (written && !current.written) {
+		s.signal()
+		return
+	}
+
+	if err == nil {
+		err = current.err
+	}
+
+	s.metrics.CommandFinished(current.cmd.Command(), time.Since(current.sentAt), err)
+	s.end(current, err)
+
 	s.current = nil
+	s.signal()
+	s.checkDrained()
+}
+
+// Close stops accepting commands, anything queued afterwards fails with err right away.
+// The returned channel is closed once every command queued before has finished
+func (s *Dispatcher) Close(err error) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed == nil {
+		s.closed = err
+		s.drained = make(chan struct{})
+		s.release()
+		s.checkDrained()
+	}
+
+	return s.drained
+}
+
+// FailQueued fails every command that hasn't been written yet with err.
+// The command in flight, if any, is left alone
+func (s *Dispatcher) FailQueued(err error) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	for elem := s.queue.Front(); elem != nil; elem = s.queue.Front() {
+		it := s.queue.Remove(elem).(*item)
+		it.elem = nil
+		s.end(it, err)
+	}
+
+	s.release()
+	s.checkDrained()
+}
+
+// checkDrained closes drained once we're closed and there's nothing left to do.
+// It must be called with the lock held
+func (s *Dispatcher) checkDrained() {
+	if s.closed == nil || s.current != nil || s.queue.Len() > 0 {
+		return
+	}
+
+	select {
+	case <-s.drained:
+	default:
+		close(s.drained)
+	}
+}
+
+// Ready fires whenever a command may be available from Next
+func (s *Dispatcher) Ready() <-chan struct{} { return s.notify }
+
+func (s *Dispatcher) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default: // A wake up is already pending
+	}
 }
 
 func (s *Dispatcher) OnMsg(msg string) {
 	// Strip the trailing newline
-	msg = strings.Trim(msg, "")
+	msg = strings.TrimSuffix(msg, "\n")
 
+	s.mu.Lock()
+	current := s.current
+
+	// Lines before the command was written belong to one that expired while in flight
+	if current == nil || !current.written {
+		s.mu.Unlock()
+		return
+	}
+
+	current.seen++
+	skip := current.cmd.SkipFirstMsg() && current.seen == 1
+
+	if skip {
+		current.span.AddEvent("header")
+	} else {
+		current.span.AddEvent("row", trace.WithAttributes(attribute.Int("rcon.row", current.rows())))
+	}
+
+	if current.done != nil {
+		if skip {
+			current.resp.Header = msg
+		} else {
+			current.resp.Rows = append(current.resp.Rows, msg)
+		}
+	}
+	s.mu.Unlock()
+
+	// Invoke the callback without holding the lock so it may enqueue further commands
+	if !skip && current.cb != nil {
+		current.cb(current.cmd, msg)
+	}
+}
+
+func (s *Dispatcher) Len() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	s.current.seen++
-	if s.current == nil || s.current.cmd.SkipFirstMsg() && s.current.seen == 1 {
-		return
+	return s.queue.Len()
+}
+
+// Enqueue queues cmd, cmdcb is called for every response line. It fails if the queue is full
+// and the QueuePolicy doesn't allow waiting, or the Dispatcher was closed
+func (s *Dispatcher) Enqueue(cmd ICommand, cmdcb HandleCommandResp) error {
+	return s.EnqueueContext(context.Background(), cmd, cmdcb)
+}
+
+// EnqueueContext queues cmd like Enqueue, its span is a child of any span in ctx.
+// With QueueBlock it waits for room in the queue until ctx is done
+func (s *Dispatcher) EnqueueContext(ctx context.Context, cmd ICommand, cmdcb HandleCommandResp) error {
+	return s.EnqueueWithDone(ctx, cmd, cmdcb, nil)
+}
+
+// EnqueueWithDone queues cmd like EnqueueContext. donecb is called exactly once when the command
+// finished, with the error it failed with if the game server rejected it, it timed out, was dropped
+// or cancelled, or the connection was lost. It's called even if the command couldn't be queued
+func (s *Dispatcher) EnqueueWithDone(ctx context.Context, cmd ICommand, cmdcb HandleCommandResp, donecb HandleCommandDone) error {
+	return s.add(ctx, &item{
+		cmd:    cmd,
+		cb:     cmdcb,
+		onDone: donecb,
+	}, true)
+}
+
+// Submit queues cmd like Enqueue but also collects every response line
+// so the caller can wait for the command to finish
+func (s *Dispatcher) Submit(cmd ICommand, cmdcb HandleCommandResp) (*Pending, error) {
+	return s.SubmitContext(context.Background(), cmd, cmdcb)
+}
+
+// SubmitContext queues cmd like Submit, its span is a child of any span in ctx.
+// With QueueBlock it waits for room in the queue until ctx is done
+func (s *Dispatcher) SubmitContext(ctx context.Context, cmd ICommand, cmdcb HandleCommandResp) (*Pending, error) {
+	return s.submit(ctx, cmd, cmdcb, true)
+}
+
+// TrySubmit queues cmd like Submit but never waits for, nor makes, room in the queue.
+// It fails with ErrQueueFull instead whatever the QueuePolicy
+func (s *Dispatcher) TrySubmit(cmd ICommand, cmdcb HandleCommandResp) (*Pending, error) {
+	return s.submit(context.Background(), cmd, cmdcb, false)
+}
+
+func (s *Dispatcher) submit(ctx context.Context, cmd ICommand, cmdcb HandleCommandResp, wait bool) (*Pending, error) {
+	it := &item{
+		cmd:  cmd,
+		cb:   cmdcb,
+		resp: Response{Command: cmd},
+		done: make(chan struct{}),
 	}
 
-	s.current.cb(s.current.cmd, msg)
+	if err := s.add(ctx, it, wait); err != nil {
+		return nil, err
+	}
+
+	return &Pending{it: it, d: s}, nil
 }
 
-func (s *Dispatcher) Len() int { return s.queue.Len() }
+// add queues it, making room according to the QueuePolicy. If it can't be queued
+// it's finished with the returned error
+func (s *Dispatcher) add(ctx context.Context, it *item, wait bool) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	attrs := append([]attribute.KeyValue{attribute.String("rcon.command.name", it.cmd.Command())}, s.attrs...)
+	_, it.span = s.tracer.Start(ctx, "rcon "+it.cmd.Command(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	for {
+		if s.closed != nil {
+			s.end(it, s.closed)
+			return s.closed
+		}
+
+		if s.limit <= 0 || s.queue.Len() < s.limit {
+			break
+		}
+
+		switch {
+		case !wait:
+			// Callers that can't wait mustn't push anyone else out either
+			s.end(it, ErrQueueFull)
+			return ErrQueueFull
+		case s.policy == QueueDropOldest:
+			oldest := s.queue.Remove(s.queue.Front()).(*item)
+			oldest.elem = nil
+			s.end(oldest, ErrDropped)
+		case s.policy == QueueBlock:
+			freed := s.freed
+
+			s.mu.Unlock()
+			select {
+			case <-freed:
+				s.mu.Lock()
+			case <-ctx.Done():
+				s.mu.Lock()
+				s.end(it, ctx.Err())
+				return ctx.Err()
+			}
+		default:
+			s.end(it, ErrQueueFull)
+			return ErrQueueFull
+		}
+	}
+
+	it.elem = s.queue.PushBack(it)
+	s.watch(ctx, it)
+	s.signal()
+	return nil
+}
 
-func (s *Dispatcher) Enqueue(cmd ICommand, cmdcb HandleCommandResp) {
+// watch expires it once ctx is done or its timeout passed, whether it's still queued or in flight.
+// It must be called with the lock held
+func (s *Dispatcher) watch(ctx context.Context, it *item) {
+	stopCtx := context.AfterFunc(ctx, func() { s.expire(it, ctx.Err()) })
+
+	var timer *time.Timer
+	if d := s.timeoutFor(it.cmd); d > 0 {
+		timer = time.AfterFunc(d, func() { s.expire(it, ErrCommandTimeout) })
+	}
+
+	it.stop = func() {
+		stopCtx()
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// expire finishes it with err and, if it was in flight, moves on to the next command.
+// Lines the game server still sends for it are attributed to the next command
+func (s *Dispatcher) expire(it *item, err error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.unlock()
+
+	if it.finished {
+		return
+	}
+
+	switch {
+	case it.elem != nil:
+		s.queue.Remove(it.elem)
+		it.elem = nil
+		s.release()
+	case s.current == it:
+		s.metrics.CommandFinished(it.cmd.Command(), time.Since(it.sentAt), err)
+		s.current = nil
+		s.signal()
+	}
+
+	s.end(it, err)
+	s.checkDrained()
+}
+
+// end finishes it with err and queues its done callback to run once the lock is released.
+// It must be called with the lock held
+func (s *Dispatcher) end(it *item, err error) {
+	if it.finished {
+		return
+	}
+
+	it.end(err)
+	if it.onDone != nil {
+		s.callbacks = append(s.callbacks, func() { it.onDone(it.cmd, err) })
+	}
+}
+
+// unlock releases the lock, then runs the done callbacks of every command finished while it was held
+// so they may queue further commands
+func (s *Dispatcher) unlock() {
+	callbacks := s.callbacks
+	s.callbacks = nil
+	s.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn()
+	}
+}
 
-	s.queue.PushBack(&item{
-		cmd: cmd,
-		cb:  cmdcb,
-	})
+// release wakes up everyone waiting for room in the queue. It must be called with the lock held
+func (s *Dispatcher) release() {
+	close(s.freed)
+	s.freed = make(chan struct{})
 }
@@ -0,0 +1,281 @@
+package commands_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tankbusta/renx-rcon/commands"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDispatcherSubmit(t *testing.T) {
+	d := commands.NewDispatcher()
+	cmd := commands.NewServerInfoCommand()
+
+	pending, err := d.Submit(cmd, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, d.Len())
+	require.Equal(t, cmd, d.Next())
+	require.Nil(t, d.Next(), "only one command may be in flight")
+
+	d.CommandWritten()
+	d.OnMsg("PORT\x02SERVERNAME\n")
+	d.OnMsg("7777\x02Renegade X Server\n")
+	d.CommandDone()
+
+	<-pending.Done()
+	resp := pending.Response()
+	require.NoError(t, resp.Err)
+	require.Equal(t, "PORT\x02SERVERNAME", resp.Header)
+	require.Equal(t, []string{"7777\x02Renegade X Server"}, resp.Rows)
+}
+
+func TestDispatcherSubmitFailed(t *testing.T) {
+	d := commands.NewDispatcher()
+	serverErr := errors.New("Unknown command")
+
+	require.False(t, d.CommandError(serverErr), "no command in flight")
+
+	pending, err := d.Submit(commands.NewListBotsCommand(), nil)
+	require.NoError(t, err)
+	next, err := d.Submit(commands.NewServerInfoCommand(), nil)
+	require.NoError(t, err)
+
+	d.Next()
+	require.False(t, d.CommandError(serverErr), "the command wasn't written yet")
+	d.CommandWritten()
+	require.True(t, d.CommandError(serverErr))
+
+	select {
+	case <-pending.Done():
+		t.Fatal("the command must keep going until the game server finished it")
+	default:
+	}
+	require.Nil(t, d.Next())
+
+	d.CommandDone()
+	<-pending.Done()
+	require.ErrorIs(t, pending.Response().Err, serverErr)
+
+	d.Next()
+	d.CommandWritten()
+	d.OnMsg("PORT\x02SERVERNAME\n")
+	d.CommandDone()
+
+	<-next.Done()
+	require.NoError(t, next.Response().Err)
+	require.Equal(t, "PORT\x02SERVERNAME", next.Response().Header)
+}
+
+func TestDispatcherCancel(t *testing.T) {
+	d := commands.NewDispatcher()
+
+	pending, err := d.Submit(commands.NewListBotsCommand(), nil)
+	require.NoError(t, err)
+	require.True(t, pending.Cancel())
+	require.Nil(t, d.Next())
+	require.False(t, pending.Cancel())
+}
+
+func TestDispatcherTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	d := commands.NewDispatcher()
+	d.SetTracer(tp.Tracer("test"), attribute.String("server.address", "127.0.0.1:7777"))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "kick")
+	pending, err := d.SubmitContext(ctx, commands.NewServerInfoCommand(), nil)
+	require.NoError(t, err)
+	d.Next()
+	d.CommandWritten()
+	d.OnMsg("PORT\x02SERVERNAME\n")
+	d.OnMsg("7777\x02Renegade X Server\n")
+	d.CommandDone()
+	parent.End()
+
+	<-pending.Done()
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	span := spans[0]
+	require.Equal(t, "rcon ServerInfo", span.Name())
+	require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	require.Contains(t, span.Attributes(), attribute.String("rcon.command.name", "ServerInfo"))
+	require.Contains(t, span.Attributes(), attribute.String("server.address", "127.0.0.1:7777"))
+	require.Contains(t, span.Attributes(), attribute.Int("rcon.command.rows", 1))
+
+	var names []string
+	for _, event := range span.Events() {
+		names = append(names, event.Name)
+	}
+	require.Equal(t, []string{"dequeued", "written", "header", "row", "finished"}, names)
+}
+
+func TestDispatcherQueueLimit(t *testing.T) {
+	t.Run("Reject", func(t *testing.T) {
+		d := commands.NewDispatcher()
+		d.SetQueueLimit(1, commands.QueueReject)
+
+		require.NoError(t, d.Enqueue(commands.NewListBotsCommand(), nil))
+		require.ErrorIs(t, d.Enqueue(commands.NewListBotsCommand(), nil), commands.ErrQueueFull)
+		require.Equal(t, 1, d.Len())
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		d := commands.NewDispatcher()
+		d.SetQueueLimit(1, commands.QueueDropOldest)
+
+		oldest, err := d.Submit(commands.NewListBotsCommand(), nil)
+		require.NoError(t, err)
+
+		_, err = d.TrySubmit(commands.NewKeepAliveCommand(), nil)
+		require.ErrorIs(t, err, commands.ErrQueueFull, "TrySubmit never drops queued commands")
+
+		cmd := commands.NewServerInfoCommand()
+		require.NoError(t, d.Enqueue(cmd, nil))
+
+		<-oldest.Done()
+		require.ErrorIs(t, oldest.Response().Err, commands.ErrDropped)
+		require.Equal(t, cmd, d.Next())
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		d := commands.NewDispatcher()
+		d.SetQueueLimit(1, commands.QueueBlock)
+		require.NoError(t, d.Enqueue(commands.NewListBotsCommand(), nil))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, d.EnqueueContext(ctx, commands.NewListBotsCommand(), nil), context.DeadlineExceeded)
+
+		_, err := d.TrySubmit(commands.NewKeepAliveCommand(), nil)
+		require.ErrorIs(t, err, commands.ErrQueueFull)
+
+		queued := make(chan error, 1)
+		go func() { queued <- d.EnqueueContext(context.Background(), commands.NewServerInfoCommand(), nil) }()
+
+		d.Next()
+		require.NoError(t, <-queued)
+		require.Equal(t, 1, d.Len())
+	})
+}
+
+func TestDispatcherTimeout(t *testing.T) {
+	d := commands.NewDispatcher()
+	d.SetTimeout(time.Hour)
+	d.SetCommandTimeout("BotVarList", 20*time.Millisecond)
+
+	stalled, err := d.Submit(commands.NewListBotsCommand(), nil)
+	require.NoError(t, err)
+
+	next := commands.NewServerInfoCommand()
+	require.NoError(t, d.Enqueue(next, nil))
+	require.Equal(t, commands.NewListBotsCommand(), d.Next())
+
+	// The game server never finishes the first command
+	<-stalled.Done()
+	require.ErrorIs(t, stalled.Response().Err, commands.ErrCommandTimeout)
+	require.Equal(t, next, d.Next())
+}
+
+func TestDispatcherTimeoutLateResponse(t *testing.T) {
+	d := commands.NewDispatcher()
+	d.SetCommandTimeout("BotVarList", 20*time.Millisecond)
+
+	stalled, err := d.Submit(commands.NewListBotsCommand(), nil)
+	require.NoError(t, err)
+	next, err := d.Submit(commands.NewServerInfoCommand(), nil)
+	require.NoError(t, err)
+
+	d.Next()
+	d.CommandWritten()
+	<-stalled.Done()
+	require.ErrorIs(t, stalled.Response().Err, commands.ErrCommandTimeout)
+
+	// Whatever the game server still sends for the expired command belongs to neither
+	d.Next()
+	d.OnMsg("PLAYERID\x02NAME\n")
+	require.False(t, d.CommandError(errors.New("Unknown command")))
+	d.CommandDone()
+
+	select {
+	case <-next.Done():
+		t.Fatal("the next command finished before it was written")
+	default:
+	}
+
+	d.CommandWritten()
+	d.OnMsg("PORT\x02SERVERNAME\n")
+	d.CommandDone()
+
+	<-next.Done()
+	require.NoError(t, next.Response().Err)
+	require.Equal(t, "PORT\x02SERVERNAME", next.Response().Header)
+}
+
+func TestDispatcherContextCancel(t *testing.T) {
+	d := commands.NewDispatcher()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inFlight, err := d.SubmitContext(ctx, commands.NewListBotsCommand(), nil)
+	require.NoError(t, err)
+
+	queued, err := d.SubmitContext(ctx, commands.NewListBotsCommand(), nil)
+	require.NoError(t, err)
+
+	d.Next()
+	cancel()
+
+	for _, pending := range []*commands.Pending{inFlight, queued} {
+		<-pending.Done()
+		require.ErrorIs(t, pending.Response().Err, context.Canceled)
+	}
+
+	require.Zero(t, d.Len())
+	require.NoError(t, d.Enqueue(commands.NewServerInfoCommand(), nil))
+	require.NotNil(t, d.Next(), "the dispatcher moved on from the cancelled command")
+}
+
+func TestDispatcherDoneCallback(t *testing.T) {
+	d := commands.NewDispatcher()
+	d.SetQueueLimit(2, commands.QueueDropOldest)
+	d.SetCommandTimeout("BotVarList", 20*time.Millisecond)
+
+	results := make(chan error, 10)
+	done := func(cmd commands.ICommand, err error) { results <- err }
+	ctx := context.Background()
+
+	// Finished by the game server, callbacks run without the lock held so they may queue more commands
+	require.NoError(t, d.EnqueueWithDone(ctx, commands.NewServerInfoCommand(), nil, func(cmd commands.ICommand, err error) {
+		results <- err
+		require.NoError(t, d.Enqueue(commands.NewKeepAliveCommand(), nil))
+	}))
+	d.Next()
+	d.CommandWritten()
+	d.CommandDone()
+	require.NoError(t, <-results)
+	require.Equal(t, 1, d.Len())
+	d.FailQueued(nil)
+
+	// Never finished
+	require.NoError(t, d.EnqueueWithDone(ctx, commands.NewListBotsCommand(), nil, done))
+	d.Next()
+	require.ErrorIs(t, <-results, commands.ErrCommandTimeout)
+
+	// Pushed out of a full queue, then the rest of it is failed
+	for i := 0; i < 3; i++ {
+		require.NoError(t, d.EnqueueWithDone(ctx, commands.NewServerInfoCommand(), nil, done))
+	}
+	require.ErrorIs(t, <-results, commands.ErrDropped)
+
+	lost := errors.New("connection lost")
+	d.FailQueued(lost)
+	require.ErrorIs(t, <-results, lost)
+	require.ErrorIs(t, <-results, lost)
+}
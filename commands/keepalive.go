@@ -0,0 +1,25 @@
+package commands
+
+// KeepAliveCommand is a cheap command sent when the connection has been idle,
+// the game server disconnects RCON clients that haven't sent anything in 60 seconds
+type KeepAliveCommand struct{}
+
+func NewKeepAliveCommand() KeepAliveCommand {
+	return KeepAliveCommand{}
+}
+
+func (s KeepAliveCommand) SkipFirstMsg() bool {
+	return false
+}
+
+func (s KeepAliveCommand) Command() string {
+	return "ping"
+}
+
+func (s KeepAliveCommand) MarshalRCON() []byte {
+	return []byte("c" + s.Command() + "\n")
+}
+
+func (s KeepAliveCommand) UnmarshalRCON(msg string, v any) error {
+	return nil // Nothing of interest in the response
+}
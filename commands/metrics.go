@@ -0,0 +1,19 @@
+package commands
+
+import "time"
+
+// Metrics receives measurements about the commands going through a Dispatcher.
+// Implementations must be safe for concurrent use
+type Metrics interface {
+	// CommandSent is called when a command is handed to the connection to be written
+	CommandSent(name string)
+
+	// CommandFinished is called once the game server finished executing a command, latency is
+	// measured from CommandSent. err is set if the server rejected the command or it never completed
+	CommandFinished(name string, latency time.Duration, err error)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) CommandSent(string)                           {}
+func (noopMetrics) CommandFinished(string, time.Duration, error) {}
@@ -0,0 +1,41 @@
+package commands
+
+import "errors"
+
+var (
+	// ErrQueueFull is returned when a command can't be queued because the queue is at its limit
+	ErrQueueFull = errors.New("rcon: command queue is full")
+
+	// ErrDropped is the error a queued command finishes with when QueueDropOldest made room for a newer one
+	ErrDropped = errors.New("rcon: command dropped from a full queue")
+
+	// ErrCommandTimeout is the error a command finishes with when it didn't finish within its timeout
+	ErrCommandTimeout = errors.New("rcon: command timed out")
+)
+
+// QueuePolicy decides what happens to a new command when the Dispatcher's queue is full
+type QueuePolicy int
+
+const (
+	// QueueBlock waits for room in the queue until the caller's context is done
+	QueueBlock QueuePolicy = iota
+
+	// QueueReject fails the new command with ErrQueueFull
+	QueueReject
+
+	// QueueDropOldest drops the oldest queued command to make room, it finishes with ErrDropped
+	QueueDropOldest
+)
+
+func (s QueuePolicy) String() string {
+	switch s {
+	case QueueBlock:
+		return "Block"
+	case QueueReject:
+		return "Reject"
+	case QueueDropOldest:
+		return "DropOldest"
+	default:
+		return "Unknown"
+	}
+}
@@ -0,0 +1,111 @@
+package rcon
+
+import "time"
+
+// ConnState is where a Server is in the lifecycle of its RCON connection
+type ConnState int32
+
+const (
+	// StateDisconnected means there's no connection to the game server
+	StateDisconnected ConnState = iota
+
+	// StateDialing means we're opening the TCP connection
+	StateDialing
+
+	// StateAuthenticating means the password was sent and we're waiting for the server to accept it
+	StateAuthenticating
+
+	// StateAuthenticated means the game server accepted our credentials and commands can be sent
+	StateAuthenticated
+
+	// StateStreaming means we're authenticated and subscribed to the game server's event stream
+	StateStreaming
+
+	// StateClosing means the connection is being torn down on request
+	StateClosing
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "Disconnected"
+	case StateDialing:
+		return "Dialing"
+	case StateAuthenticating:
+		return "Authenticating"
+	case StateAuthenticated:
+		return "Authenticated"
+	case StateStreaming:
+		return "Streaming"
+	case StateClosing:
+		return "Closing"
+	default:
+		return "Unknown"
+	}
+}
+
+// Names of the connections a StateChange may be about
+const (
+	// ConnectionShared carries both commands and the event stream, unless WithSplitConnections is used
+	ConnectionShared = "shared"
+
+	// ConnectionCommands only carries commands, see WithSplitConnections
+	ConnectionCommands = "commands"
+
+	// ConnectionStream only carries the event stream, see WithSplitConnections
+	ConnectionStream = "stream"
+)
+
+// StateChange describes a single transition of a Server's ConnState
+type StateChange struct {
+	// Connection is the connection that changed state, one of the Connection constants.
+	// With WithSplitConnections, ConnectionStream transitions are reported by StreamState instead of State
+	Connection string
+
+	From ConnState
+	To   ConnState
+	At   time.Time
+}
+
+// State returns where the server currently is in its connection lifecycle.
+// It's safe to call from any goroutine
+func (s *Server) State() ConnState {
+	return ConnState(s.state.Load())
+}
+
+// IsConnected reports if we have a connection to the game server, authenticated or not
+func (s *Server) IsConnected() bool {
+	switch s.State() {
+	case StateAuthenticating, StateAuthenticated, StateStreaming:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsAuthenticated reports if the game server has accepted our RCON credentials
+func (s *Server) IsAuthenticated() bool {
+	switch s.State() {
+	case StateAuthenticated, StateStreaming:
+		return true
+	default:
+		return false
+	}
+}
+
+// OnStateChange calls fn for every ConnState transition of either connection, in order, from a dedicated goroutine.
+// Call the returned function to unsubscribe
+func (s *Server) OnStateChange(fn func(StateChange)) (unsubscribe func()) {
+	sub, unsubscribe := s.stateChanges.subscribe(EventQueueSize, nil)
+	sub.callback(fn)
+
+	return unsubscribe
+}
+
+// StateChanges returns a channel receiving every ConnState transition of either connection.
+// Transitions are dropped if the channel's buffer is full. Call the returned
+// function to unsubscribe, which also closes the channel
+func (s *Server) StateChanges(buffer int) (<-chan StateChange, func()) {
+	sub, unsubscribe := s.stateChanges.subscribe(buffer, nil)
+	return sub.ch, unsubscribe
+}
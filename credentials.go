@@ -0,0 +1,109 @@
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrNoPassword is returned by a CredentialProvider that came up empty
+var ErrNoPassword = errors.New("rcon: no RCON password available")
+
+// CredentialProvider supplies the RCON password.
+//
+// It's asked every time a connection is opened, so a rotated password is picked up on the next reconnect
+type CredentialProvider interface {
+	Password(ctx context.Context) (string, error)
+}
+
+// CredentialFunc adapts a function to a CredentialProvider, such as one asking a secrets manager
+type CredentialFunc func(ctx context.Context) (string, error)
+
+func (s CredentialFunc) Password(ctx context.Context) (string, error) {
+	return s(ctx)
+}
+
+// StaticPassword always returns password
+func StaticPassword(password string) CredentialProvider {
+	return staticPassword(password)
+}
+
+// RotatingCredentials marks p as a source whose password may change while we're running, such
+// as a secrets manager. A password the game server rejects is then retried according to the
+// ReconnectPolicy, since p may simply not have caught up with a rotation yet. Any other provider
+// is expected to keep returning the same password, so a rejected one is given up on right away
+func RotatingCredentials(p CredentialProvider) CredentialProvider {
+	return rotatingCredentials{p}
+}
+
+type rotatingCredentials struct {
+	CredentialProvider
+}
+
+// staticPassword is the password given to NewServer
+type staticPassword string
+
+func (s staticPassword) Password(context.Context) (string, error) {
+	if s == "" {
+		return "", ErrNoPassword
+	}
+
+	return string(s), nil
+}
+
+// rotates reports if p was marked by RotatingCredentials
+func rotates(p CredentialProvider) bool {
+	_, ok := p.(rotatingCredentials)
+	return ok
+}
+
+// EnvPassword reads the password from the environment variable name
+func EnvPassword(name string) CredentialProvider {
+	return CredentialFunc(func(context.Context) (string, error) {
+		password := os.Getenv(name)
+		if password == "" {
+			return "", fmt.Errorf("%w: $%s is empty", ErrNoPassword, name)
+		}
+
+		return password, nil
+	})
+}
+
+// FilePassword reads the password from the file at path, such as a mounted Kubernetes or Docker secret.
+// Surrounding whitespace is ignored
+func FilePassword(path string) CredentialProvider {
+	return CredentialFunc(func(context.Context) (string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read RCON password: %w", err)
+		}
+
+		password := strings.TrimSpace(string(b))
+		if password == "" {
+			return "", fmt.Errorf("%w: %s is empty", ErrNoPassword, path)
+		}
+
+		return password, nil
+	})
+}
+
+// CommandPassword runs name with args and uses what it prints as the password, such as `pass show renx/rcon`.
+// Surrounding whitespace is ignored
+func CommandPassword(name string, args ...string) CredentialProvider {
+	return CredentialFunc(func(ctx context.Context) (string, error) {
+		out, err := exec.CommandContext(ctx, name, args...).Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to run RCON password command %s: %w", name, err)
+		}
+
+		password := strings.TrimSpace(string(out))
+		if password == "" {
+			return "", fmt.Errorf("%w: %s printed nothing", ErrNoPassword, name)
+		}
+
+		return password, nil
+	})
+}
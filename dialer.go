@@ -0,0 +1,32 @@
+package rcon
+
+import (
+	"context"
+	"net"
+)
+
+// Dialer opens the connection to a game server.
+//
+// *net.Dialer satisfies it, as do the SOCKS5 dialers from golang.org/x/net/proxy and
+// most SSH clients, so RCON can be routed through proxies and tunnels
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DialerFunc adapts an ordinary function to a Dialer
+type DialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f DialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// PipeDialer returns a Dialer creating in-memory connections with net.Pipe.
+// serve is called on its own goroutine with the game server's end of every connection
+func PipeDialer(serve func(conn net.Conn)) Dialer {
+	return DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serve(server)
+
+		return client, nil
+	})
+}
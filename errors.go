@@ -0,0 +1,50 @@
+package rcon
+
+import (
+	"errors"
+
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+// Retryable reports if reconnecting could resolve err.
+//
+// Wrong credentials and bans won't fix themselves so the reconnect loop gives up on them,
+// while network failures and a full list of RCON slots are worth another try
+func Retryable(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var perr permanentError
+	switch {
+	case errors.As(err, &perr):
+		return false
+	case errors.Is(err, events.ErrBadPassword), errors.Is(err, events.ErrBanned):
+		return false
+	case errors.Is(err, events.ErrMalformedFrame), errors.Is(err, events.ErrLineTooLong):
+		// Only happens when what we send, such as the password, can't be framed
+		return false
+	}
+
+	return true
+}
+
+// retryable reports if the reconnect loop should try again after err. On top of Retryable, a
+// rejected password is retried when it comes from RotatingCredentials, which may not have caught up yet
+func (s *Server) retryable(err error) bool {
+	if Retryable(err) {
+		return true
+	}
+
+	var perr permanentError
+	return !errors.As(err, &perr) && errors.Is(err, events.ErrBadPassword) && rotates(s.credentials)
+}
+
+// permanentError marks an error the reconnect loop must not retry
+type permanentError struct {
+	err error
+}
+
+func (s permanentError) Error() string { return s.err.Error() }
+
+func (s permanentError) Unwrap() error { return s.err }
@@ -0,0 +1,197 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxLineLength is the longest line a Decoder or Encoder accepts unless told otherwise
+const DefaultMaxLineLength = 64 * 1024
+
+var (
+	// ErrLineTooLong means a line was longer than the max line length
+	ErrLineTooLong = errors.New("events: line too long")
+
+	// ErrUnknownType means a line started with a message type we don't know about
+	ErrUnknownType = errors.New("events: unknown message type")
+
+	// ErrMalformedFrame means a line to be sent was empty or contained a newline in its body
+	ErrMalformedFrame = errors.New("events: malformed frame")
+)
+
+// FrameError is returned for a single line that couldn't be framed. It doesn't affect
+// the lines around it, so a Decoder or Encoder can keep being used afterwards
+type FrameError struct {
+	// Line is the offending line, empty if it was too long to keep around
+	Line string
+	Err  error
+}
+
+func (s *FrameError) Error() string {
+	if s.Line == "" {
+		return s.Err.Error()
+	}
+
+	return fmt.Sprintf("%s: %q", s.Err, s.Line)
+}
+
+func (s *FrameError) Unwrap() error {
+	return s.Err
+}
+
+// Message is a single line sent by the game server
+type Message struct {
+	Type ServerType
+
+	// Body is everything after the type, without the trailing newline
+	Body string
+}
+
+func (s Message) String() string {
+	return string(s.Type) + s.Body
+}
+
+func (s ServerType) valid() bool {
+	switch s {
+	case RCONGameVersion, AuthenticationSuccess, Error, CommandResponse,
+		CommandExecutionFinished, GameLog, ServerDevBot:
+		return true
+	default:
+		return false
+	}
+}
+
+// Decoder reads Messages sent by the game server
+type Decoder struct {
+	r   *bufio.Reader
+	max int
+	raw string
+}
+
+// NewDecoder returns a Decoder reading from r that rejects lines longer than maxLineLength,
+// DefaultMaxLineLength if it's zero
+func NewDecoder(r io.Reader, maxLineLength int) *Decoder {
+	if maxLineLength <= 0 {
+		maxLineLength = DefaultMaxLineLength
+	}
+
+	return &Decoder{
+		r:   bufio.NewReaderSize(r, maxLineLength),
+		max: maxLineLength,
+	}
+}
+
+// Decode reads the next Message.
+//
+// NULs and carriage returns are stripped, blank lines skipped and invalid UTF-8, which shows
+// up in player names, replaced with utf8.RuneError. Lines that are too long or of an unknown
+// type are returned as a *FrameError, decoding may continue after it. Any other error comes
+// from the underlying reader
+func (s *Decoder) Decode() (Message, error) {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return Message{}, err
+		}
+
+		s.raw = line
+
+		line = strings.TrimSuffix(line, "\n")
+		if strings.ContainsAny(line, "\x00\r") {
+			line = strings.NewReplacer("\x00", "", "\r", "").Replace(line)
+		}
+
+		if line == "" {
+			continue
+		}
+
+		msg := Message{Type: ServerType(line[0]), Body: line[1:]}
+		if !msg.Type.valid() {
+			return Message{}, &FrameError{Line: line, Err: ErrUnknownType}
+		}
+
+		if !utf8.ValidString(msg.Body) {
+			msg.Body = strings.ToValidUTF8(msg.Body, string(utf8.RuneError))
+		}
+
+		return msg, nil
+	}
+}
+
+// Raw returns the line the last call to Decode read, exactly as it was received
+func (s *Decoder) Raw() string {
+	return s.raw
+}
+
+func (s *Decoder) readLine() (string, error) {
+	line, err := s.r.ReadSlice(NewLine)
+	if !errors.Is(err, bufio.ErrBufferFull) {
+		return string(line), err
+	}
+
+	// Drain the rest of the oversized line
+	for errors.Is(err, bufio.ErrBufferFull) {
+		_, err = s.r.ReadSlice(NewLine)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	s.raw = ""
+	return "", &FrameError{Err: ErrLineTooLong}
+}
+
+// Encoder writes lines to the game server
+type Encoder struct {
+	w   io.Writer
+	max int
+}
+
+// NewEncoder returns an Encoder writing to w that refuses lines longer than maxLineLength,
+// DefaultMaxLineLength if it's zero
+func NewEncoder(w io.Writer, maxLineLength int) *Encoder {
+	if maxLineLength <= 0 {
+		maxLineLength = DefaultMaxLineLength
+	}
+
+	return &Encoder{w: w, max: maxLineLength}
+}
+
+// Encode writes a message sent by the game server. It's mostly useful to fake one in tests
+func (s *Encoder) Encode(msg Message) error {
+	return s.WriteFrame(AppendFrame(nil, msg.Type, msg.Body))
+}
+
+// EncodeClient writes a message of type typ sent by an RCON client
+func (s *Encoder) EncodeClient(typ ClientType, body string) error {
+	return s.WriteFrame(AppendFrame(nil, typ, body))
+}
+
+// WriteFrame writes a complete line, including its trailing newline.
+// A line that would be split in two by a newline in its body is refused with a *FrameError
+func (s *Encoder) WriteFrame(frame []byte) error {
+	switch {
+	case len(frame) < 2 || frame[len(frame)-1] != NewLine:
+		return &FrameError{Line: string(frame), Err: ErrMalformedFrame}
+	case bytes.IndexByte(frame[:len(frame)-1], NewLine) >= 0:
+		return &FrameError{Line: string(frame), Err: ErrMalformedFrame}
+	case len(frame) > s.max:
+		return &FrameError{Err: ErrLineTooLong}
+	}
+
+	_, err := s.w.Write(frame)
+	return err
+}
+
+// AppendFrame appends the line for a message of type typ to dst
+func AppendFrame[T SupportedMsgTypes](dst []byte, typ T, body string) []byte {
+	dst = append(dst, byte(typ))
+	dst = append(dst, body...)
+	return append(dst, NewLine)
+}
@@ -0,0 +1,59 @@
+package events_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+func TestDecoder(t *testing.T) {
+	input := strings.Join([]string{
+		"v3\x025887\x02Renegade X\r\n",
+		"\n",
+		"x not a type\n",
+		"l" + strings.Repeat("A", 64) + "\n",
+		"rPlayer\xffName\x00\n",
+		"c\n",
+	}, "")
+
+	dec := events.NewDecoder(strings.NewReader(input), 32)
+
+	msg, err := dec.Decode()
+	require.NoError(t, err)
+	require.Equal(t, events.Message{Type: events.RCONGameVersion, Body: "3\x025887\x02Renegade X"}, msg)
+	require.Equal(t, "v3\x025887\x02Renegade X\r\n", dec.Raw())
+
+	_, err = dec.Decode()
+	require.ErrorIs(t, err, events.ErrUnknownType)
+
+	_, err = dec.Decode()
+	require.ErrorIs(t, err, events.ErrLineTooLong)
+
+	msg, err = dec.Decode()
+	require.NoError(t, err)
+	require.Equal(t, events.Message{Type: events.CommandResponse, Body: "Player�Name"}, msg)
+
+	msg, err = dec.Decode()
+	require.NoError(t, err)
+	require.Equal(t, events.Message{Type: events.CommandExecutionFinished}, msg)
+
+	_, err = dec.Decode()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := events.NewEncoder(&buf, 16)
+
+	require.NoError(t, enc.EncodeClient(events.Command, "ping"))
+	require.NoError(t, enc.Encode(events.Message{Type: events.CommandExecutionFinished}))
+	require.ErrorIs(t, enc.EncodeClient(events.Command, "say hi\ncquit"), events.ErrMalformedFrame)
+	require.ErrorIs(t, enc.EncodeClient(events.Command, strings.Repeat("A", 16)), events.ErrLineTooLong)
+	require.ErrorIs(t, enc.WriteFrame([]byte("cping")), events.ErrMalformedFrame)
+
+	require.Equal(t, "cping\nc\n", buf.String())
+}
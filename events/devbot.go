@@ -0,0 +1,50 @@
+package events
+
+import (
+	"errors"
+	"strings"
+)
+
+// DevBotMessage is a message relayed over the DevBot channel.
+// Its fields are delimited the same way as every other RCON message
+type DevBotMessage struct {
+	Parts []string
+}
+
+func NewDevBotMessage(parts ...string) DevBotMessage {
+	return DevBotMessage{Parts: parts}
+}
+
+func (s DevBotMessage) String() string {
+	return strings.Join(s.Parts, " ")
+}
+
+func (s *DevBotMessage) Parse(input string) error {
+	input = strings.TrimRight(input, "\n\x00")
+	if input == "" {
+		return errors.New("events/DevBotMessage: empty message")
+	}
+
+	s.Parts = strings.Split(input, string(Delimiter))
+	return nil
+}
+
+// Validate ensures the message can be sent without corrupting the RCON stream
+func (s DevBotMessage) Validate() error {
+	if len(s.Parts) == 0 {
+		return errors.New("events/DevBotMessage: empty message")
+	}
+
+	for _, part := range s.Parts {
+		if strings.ContainsRune(part, NewLine) {
+			return errors.New("events/DevBotMessage: message may not contain a newline")
+		}
+	}
+
+	return nil
+}
+
+// MarshalRCON encodes the message to be sent to the game server
+func (s DevBotMessage) MarshalRCON() []byte {
+	return []byte(string(DevBot) + strings.Join(s.Parts, string(Delimiter)) + "\n")
+}
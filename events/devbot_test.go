@@ -0,0 +1,18 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+func TestDevBotMessage(t *testing.T) {
+	var dm events.DevBotMessage
+	require.NoError(t, dm.Parse("announce\x02Server restarting\n"))
+	require.Equal(t, []string{"announce", "Server restarting"}, dm.Parts)
+	require.Equal(t, "dannounce\x02Server restarting\n", string(dm.MarshalRCON()))
+
+	require.Error(t, dm.Parse("\n"))
+	require.Error(t, events.NewDevBotMessage("bad\nline").Validate())
+}
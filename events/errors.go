@@ -0,0 +1,59 @@
+package events
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors a ServerError may be classified as. Use errors.Is to check for them
+var (
+	ErrBadPassword        = errors.New("rcon: invalid password")
+	ErrBanned             = errors.New("rcon: banned")
+	ErrTooManyConnections = errors.New("rcon: too many connections")
+	ErrUnknownCommand     = errors.New("rcon: unknown command")
+	ErrInvalidArguments   = errors.New("rcon: invalid arguments")
+)
+
+// errorPatterns maps fragments of the messages sent by the game server to the error they represent.
+// Fragments are matched case insensitively and in order, prefix ones only at the start of the message.
+// Command and argument errors come first as they may quote an argument such as "password"
+var errorPatterns = []struct {
+	fragment string
+	prefix   bool
+	kind     error
+}{
+	{"unknown command", false, ErrUnknownCommand},
+	{"invalid command", false, ErrUnknownCommand},
+	{"non-existent command", false, ErrUnknownCommand},
+	{"invalid argument", false, ErrInvalidArguments},
+	{"invalid parameter", false, ErrInvalidArguments},
+	{"missing argument", false, ErrInvalidArguments},
+	{"missing parameter", false, ErrInvalidArguments},
+	{"usage:", true, ErrInvalidArguments},
+	{"invalid password", false, ErrBadPassword},
+	{"incorrect password", false, ErrBadPassword},
+	{"wrong password", false, ErrBadPassword},
+	{"bad password", false, ErrBadPassword},
+	{"banned", false, ErrBanned},
+	{"too many connections", false, ErrTooManyConnections},
+	{"max connections", false, ErrTooManyConnections},
+	{"connection limit", false, ErrTooManyConnections},
+	{"server full", false, ErrTooManyConnections},
+}
+
+// classifyError returns the sentinel error msg represents, or nil if it's not one we know about
+func classifyError(msg string) error {
+	msg = strings.ToLower(msg)
+
+	for _, pattern := range errorPatterns {
+		if pattern.prefix && strings.HasPrefix(msg, pattern.fragment) {
+			return pattern.kind
+		}
+
+		if !pattern.prefix && strings.Contains(msg, pattern.fragment) {
+			return pattern.kind
+		}
+	}
+
+	return nil
+}
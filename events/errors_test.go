@@ -0,0 +1,45 @@
+package events_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+func TestServerErrorClassification(t *testing.T) {
+	cases := map[string]error{
+		"Invalid password\n":            events.ErrBadPassword,
+		"You are banned from this RCON": events.ErrBanned,
+		"Too many connections":          events.ErrTooManyConnections,
+		"Unknown command: Frobnicate":   events.ErrUnknownCommand,
+		"Invalid argument: PlayerID":    events.ErrInvalidArguments,
+		"Invalid argument: password":    events.ErrInvalidArguments,
+		"Usage: Kick <PlayerID>":        events.ErrInvalidArguments,
+		"Unknown command: password":     events.ErrUnknownCommand,
+	}
+
+	for msg, expected := range cases {
+		var serverErr events.ServerError
+		require.NoError(t, serverErr.Parse(msg))
+
+		var err error = serverErr
+		require.ErrorIs(t, err, expected, msg)
+
+		var asServerErr events.ServerError
+		require.True(t, errors.As(err, &asServerErr))
+	}
+
+	var unknown events.ServerError
+	unknown.Parse("Something odd happened")
+	require.Nil(t, unknown.Kind)
+	require.Equal(t, "Something odd happened", unknown.Error())
+
+	// Mentioning a password or usage isn't enough
+	for _, msg := range []string{"Password changed", "Memory usage: 512MB"} {
+		var serverErr events.ServerError
+		serverErr.Parse(msg)
+		require.Nil(t, serverErr.Kind, msg)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 )
 
 const expectedStructTag = "rcon"
@@ -41,8 +42,10 @@ type RCONType struct {
 func (s RCONType) Parse(header, input string) (map[string]any, error) {
 	out := map[string]any{}
 
-	// Split our columns out so we know the order
-	headerParts := strings.Fields(header)
+	// Split our columns out so we know the order. Headers may be delimited by whitespace or Delimiter
+	headerParts := strings.FieldsFunc(header, func(r rune) bool {
+		return r == Delimiter || unicode.IsSpace(r)
+	})
 	if len(headerParts) > len(s.Columns) {
 		return nil, fmt.Errorf("events/RCONType: too many parts in event header. Expected < %d got %d", len(s.Columns), len(headerParts))
 	}
@@ -62,11 +65,8 @@ func (s RCONType) Parse(header, input string) (map[string]any, error) {
 	for i, part := range parts {
 		column := s.Columns[i]
 
-		// If we're on the last part, we may have a control character we need to remove
-		if isLast := len(parts)-1 == i; isLast {
-			// last := part[len(part)-1:]
-			part = strings.Trim(part, "\x00")
-		}
+		// Any column may carry stray NULs from the game server
+		part = strings.Trim(part, "\x00")
 
 		switch s.ColumnTypes[column].Kind() {
 		case reflect.String:
@@ -19,3 +19,22 @@ func TestParser(t *testing.T) {
 	require.Equal(t, int(7777), v.Port)
 	require.Equal(t, "CNC-Field", v.Map)
 }
+
+func TestParserHeaderDelimiters(t *testing.T) {
+	row := "7777\x02Renegade X Server\x02CNC-Field"
+
+	// The game server separates header columns with spaces, GenerateDefaultHeader with Delimiter
+	for _, header := range []string{
+		"PORT SERVERNAME GETPACKAGENAME\n",
+		events.Columns{"PORT", "SERVERNAME", "GETPACKAGENAME"}.GenerateDefaultHeader(),
+	} {
+		v := &events.ServerInfo{}
+		require.NoError(t, events.UnmarshalRCON(header, row, v), "header %q", header)
+		require.Equal(t, 7777, v.Port)
+		require.Equal(t, "Renegade X Server", v.Name)
+		require.Equal(t, "CNC-Field", v.Map)
+	}
+
+	err := events.UnmarshalRCON("PORT\x02BOGUS", row, &events.ServerInfo{})
+	require.ErrorContains(t, err, "unexpected column in header BOGUS")
+}
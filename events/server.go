@@ -43,6 +43,10 @@ func (s *Version) Parse(input string) error {
 
 type ServerError struct {
 	ErrorMsg string
+
+	// Kind is the sentinel error (ErrBadPassword, ErrBanned, ...) this error was classified as.
+	// It's nil if the message isn't one we recognize
+	Kind error
 }
 
 func (s ServerError) String() string {
@@ -53,8 +57,14 @@ func (s ServerError) Error() string {
 	return s.String()
 }
 
+// Unwrap allows errors.Is to match the sentinel error this was classified as
+func (s ServerError) Unwrap() error {
+	return s.Kind
+}
+
 func (s *ServerError) Parse(input string) error {
-	s.ErrorMsg = input
+	s.ErrorMsg = strings.Trim(input, "\n")
+	s.Kind = classifyError(s.ErrorMsg)
 	return nil
 }
 
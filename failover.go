@@ -0,0 +1,141 @@
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// authError is returned when the game server at addr rejected our credentials
+type authError struct {
+	addr string
+	err  error
+}
+
+func (s authError) Error() string {
+	return fmt.Sprintf("RCON authentication at %s failed: %s", s.addr, s.err)
+}
+
+func (s authError) Unwrap() error {
+	return s.err
+}
+
+// srvRecord is the SRV record resolved to find addresses, see WithSRV
+type srvRecord struct {
+	service, proto, name string
+}
+
+// ActiveAddress returns the address of the game server we're connected to, or were last
+// connected to. It's empty until the first connection was opened
+func (s *Server) ActiveAddress() string {
+	s.addrMu.Lock()
+	defer s.addrMu.Unlock()
+
+	return s.active
+}
+
+// addresses returns every address to try in order: the SRV record's targets if one is
+// configured, followed by the address given to NewServer and those added by WithAddresses
+func (s *Server) addresses(ctx context.Context) []string {
+	var addrs []string
+
+	if s.srv != nil {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, s.srv.service, s.srv.proto, s.srv.name)
+		if err != nil {
+			s.logger.Warn("failed to resolve RCON SRV record", "name", s.srv.name, "error", err)
+		}
+
+		for _, rec := range records {
+			addrs = append(addrs, net.JoinHostPort(rec.Target, strconv.Itoa(int(rec.Port))))
+		}
+	}
+
+	seen := make(map[string]struct{}, len(addrs)+len(s.fallbacks)+1)
+	out := make([]string, 0, len(addrs)+len(s.fallbacks)+1)
+
+	for _, addr := range append(append(addrs, s.Address), s.fallbacks...) {
+		if _, dup := seen[addr]; !dup {
+			seen[addr] = struct{}{}
+			out = append(out, addr)
+		}
+	}
+
+	s.addrMu.Lock()
+	s.resolved = out
+	s.addrMu.Unlock()
+
+	return out
+}
+
+// dial opens a connection to the first address that accepts one, starting with the active address
+func (s *Server) dial(ctx context.Context) (net.Conn, error) {
+	addrs := s.addresses(ctx)
+
+	start := 0
+	for i, addr := range addrs {
+		if addr == s.ActiveAddress() {
+			start = i
+			break
+		}
+	}
+
+	var errs []error
+	for i := range addrs {
+		addr := addrs[(start+i)%len(addrs)]
+
+		conn, err := s.dialAddress(ctx, addr)
+		if err == nil {
+			s.addrMu.Lock()
+			s.active = addr
+			s.addrMu.Unlock()
+
+			return conn, nil
+		}
+
+		errs = append(errs, fmt.Errorf("failed to connect to RCON at %s: %w", addr, err))
+		if ctx.Err() != nil {
+			break
+		}
+
+		if i < len(addrs)-1 {
+			s.logger.Warn("failed to connect to RCON, trying the next address", "error", err, "failed_address", addr)
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (s *Server) dialAddress(ctx context.Context, addr string) (net.Conn, error) {
+	if s.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.dialTimeout)
+		defer cancel()
+	}
+
+	return s.dialer.DialContext(ctx, s.network, addr)
+}
+
+// failover makes the address after addr the next one tried and returns it
+func (s *Server) failover(addr string) string {
+	s.addrMu.Lock()
+	defer s.addrMu.Unlock()
+
+	for i, known := range s.resolved {
+		if known == addr {
+			s.active = s.resolved[(i+1)%len(s.resolved)]
+			break
+		}
+	}
+
+	return s.active
+}
+
+// addressCount returns how many addresses the last dial had to choose from
+func (s *Server) addressCount() int {
+	s.addrMu.Lock()
+	defer s.addrMu.Unlock()
+
+	return len(s.resolved)
+}
@@ -0,0 +1,283 @@
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUnknownServer is returned when a Fleet has no server by the given name
+	ErrUnknownServer = errors.New("rcon/fleet: unknown server")
+
+	// ErrServerExists is returned when adding a server under a name that's already taken
+	ErrServerExists = errors.New("rcon/fleet: server already exists")
+
+	// ErrServerRunning is returned when starting a server that's already running
+	ErrServerRunning = errors.New("rcon/fleet: server already running")
+)
+
+// FleetEvent is an Event tagged with the name of the server it came from
+type FleetEvent struct {
+	// Server is the name the originating server was added to the Fleet with
+	Server string
+
+	Event
+}
+
+// FleetStatus is a point in time snapshot of a server within a Fleet
+type FleetStatus struct {
+	// Running indicates Start has been called and the server hasn't stopped yet
+	Running bool
+
+	// Ready mirrors Server.Ready
+	Ready bool
+
+	// State mirrors Server.State
+	State ConnState
+
+	// ActiveAddress mirrors Server.ActiveAddress
+	ActiveAddress string
+
+	// Err is the error the server last stopped with, if any
+	Err error
+}
+
+type fleetMember struct {
+	server      *Server
+	unsubscribe func()
+
+	// cancel and done are set while the server is running
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Fleet owns many game servers within a single process and merges
+// all of their events into a single stream
+type Fleet struct {
+	// unexported fields below
+	members map[string]*fleetMember
+	events  *eventHub[FleetEvent]
+	mu      sync.RWMutex
+}
+
+func NewFleet() *Fleet {
+	return &Fleet{
+		members: make(map[string]*fleetMember),
+		events:  newEventHub[FleetEvent](),
+	}
+}
+
+// Add registers svr under name. The server isn't started until Start is called
+func (s *Fleet) Add(name string, svr *Server) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.members[name]; ok {
+		return fmt.Errorf("%w: %s", ErrServerExists, name)
+	}
+
+	s.members[name] = &fleetMember{
+		server: svr,
+		unsubscribe: svr.OnEvent(func(ev Event) {
+			s.events.publish(FleetEvent{Server: name, Event: ev})
+		}),
+	}
+
+	return nil
+}
+
+// Remove stops the server registered under name and removes it from the Fleet
+func (s *Fleet) Remove(name string) error {
+	for {
+		s.mu.Lock()
+		member, ok := s.members[name]
+		if !ok {
+			s.mu.Unlock()
+			return fmt.Errorf("%w: %s", ErrUnknownServer, name)
+		}
+
+		// Only a stopped server is removed, checked under the same lock so it can't be started in between
+		if member.done == nil {
+			member.unsubscribe()
+			delete(s.members, name)
+			s.mu.Unlock()
+
+			return nil
+		}
+
+		cancel, done := member.cancel, member.done
+		s.mu.Unlock()
+
+		// The error the server stopped with doesn't matter since we're discarding it
+		cancel()
+		<-done
+	}
+}
+
+// Server returns the server registered under name
+func (s *Fleet) Server(name string) (*Server, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	member, ok := s.members[name]
+	if !ok {
+		return nil, false
+	}
+
+	return member.server, true
+}
+
+// Names returns the name of every server in the Fleet, sorted
+func (s *Fleet) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.members))
+	for name := range s.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Start runs the server registered under name in the background until ctx is cancelled or Stop is called
+func (s *Fleet) Start(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	member, ok := s.members[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownServer, name)
+	}
+
+	if member.done != nil {
+		return fmt.Errorf("%w: %s", ErrServerRunning, name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	member.cancel = cancel
+	member.done = done
+	member.err = nil
+
+	go func() {
+		err := member.server.Start(ctx)
+		cancel()
+
+		s.mu.Lock()
+		member.err = err
+		member.cancel = nil
+		member.done = nil
+		s.mu.Unlock()
+
+		close(done)
+	}()
+
+	return nil
+}
+
+// StartAll starts every server in the Fleet that isn't already running
+func (s *Fleet) StartAll(ctx context.Context) {
+	for _, name := range s.Names() {
+		// Already running or removed in the meantime
+		_ = s.Start(ctx, name)
+	}
+}
+
+// Stop the server registered under name and wait for it to shut down.
+// It returns the error the server stopped with, if any
+func (s *Fleet) Stop(name string) error {
+	s.mu.RLock()
+	member, ok := s.members[name]
+	if !ok {
+		s.mu.RUnlock()
+		return fmt.Errorf("%w: %s", ErrUnknownServer, name)
+	}
+
+	cancel, done := member.cancel, member.done
+	s.mu.RUnlock()
+
+	if done == nil {
+		return nil // Not running
+	}
+
+	cancel()
+	<-done
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return member.err
+}
+
+// StopAll stops every server in the Fleet
+func (s *Fleet) StopAll() {
+	var wg sync.WaitGroup
+
+	for _, name := range s.Names() {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			s.Stop(name)
+		}(name)
+	}
+
+	wg.Wait()
+}
+
+// Ready reports if the server registered under name is connected and authenticated
+func (s *Fleet) Ready(name string) bool {
+	svr, ok := s.Server(name)
+	return ok && svr.Ready()
+}
+
+// Status returns a snapshot of every server in the Fleet keyed by name
+func (s *Fleet) Status() map[string]FleetStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make(map[string]FleetStatus, len(s.members))
+	for name, member := range s.members {
+		out[name] = FleetStatus{
+			Running:       member.done != nil,
+			Ready:         member.server.Ready(),
+			State:         member.server.State(),
+			ActiveAddress: member.server.ActiveAddress(),
+			Err:           member.err,
+		}
+	}
+
+	return out
+}
+
+// OnEvent calls fn for every event from any server in the Fleet matching any of filters.
+// Call the returned function to unsubscribe
+func (s *Fleet) OnEvent(fn func(FleetEvent), filters ...EventFilter) (unsubscribe func()) {
+	sub, unsubscribe := s.events.subscribe(EventQueueSize, fleetFilters(filters))
+	sub.callback(fn)
+
+	return unsubscribe
+}
+
+// Events returns a channel receiving every event from any server in the Fleet matching any of filters.
+// Call the returned function to unsubscribe, which also closes the channel
+func (s *Fleet) Events(buffer int, filters ...EventFilter) (<-chan FleetEvent, func()) {
+	sub, unsubscribe := s.events.subscribe(buffer, fleetFilters(filters))
+	return sub.ch, unsubscribe
+}
+
+func fleetFilters(filters []EventFilter) []func(FleetEvent) bool {
+	out := make([]func(FleetEvent) bool, len(filters))
+	for i, filter := range filters {
+		filter := filter
+		out[i] = func(ev FleetEvent) bool { return filter(ev.Event) }
+	}
+
+	return out
+}
@@ -0,0 +1,103 @@
+package rcon_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	rcon "github.com/tankbusta/renx-rcon"
+	"github.com/tankbusta/renx-rcon/events"
+	"github.com/tankbusta/renx-rcon/rcontest"
+)
+
+func TestFleet(t *testing.T) {
+	eu := rcontest.NewServer(testPassword)
+	defer eu.Close()
+
+	us := rcontest.NewServer(testPassword)
+	defer us.Close()
+
+	fleet := rcon.NewFleet()
+	require.NoError(t, fleet.Add("eu-1", newServer(t, testPassword, eu.Addr)))
+	require.NoError(t, fleet.Add("us-1", newServer(t, testPassword, us.Addr)))
+	require.ErrorIs(t, fleet.Add("eu-1", newServer(t, testPassword, eu.Addr)), rcon.ErrServerExists)
+	require.Equal(t, []string{"eu-1", "us-1"}, fleet.Names())
+
+	logs, unsubscribe := fleet.Events(10, rcon.FilterLog(events.LogTypeChat, events.ActivitySay))
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer fleet.StopAll()
+
+	fleet.StartAll(ctx)
+	require.ErrorIs(t, fleet.Start(ctx, "eu-1"), rcon.ErrServerRunning)
+	require.ErrorIs(t, fleet.Start(ctx, "ap-1"), rcon.ErrUnknownServer)
+
+	subscribed := func() bool { return eu.Subscribed() == 1 && us.Subscribed() == 1 }
+	require.Eventually(t, subscribed, 2*time.Second, 5*time.Millisecond)
+
+	for name, status := range fleet.Status() {
+		require.True(t, status.Running, name)
+		require.True(t, status.Ready, name)
+		require.Equal(t, rcon.StateStreaming, status.State, name)
+		require.NoError(t, status.Err, name)
+	}
+	require.Equal(t, us.Addr, fleet.Status()["us-1"].ActiveAddress)
+
+	// Events are tagged with the name of the server they came from
+	us.EmitLog("CHAT\x02Say;\x02player\x02gg")
+	select {
+	case ev := <-logs:
+		require.Equal(t, "us-1", ev.Server)
+		require.Equal(t, []string{"player", "gg"}, ev.Log.Parts)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for chat event")
+	}
+
+	require.NoError(t, fleet.Stop("eu-1"))
+	require.Eventually(t, func() bool { return eu.Connections() == 0 }, 2*time.Second, 5*time.Millisecond)
+
+	status := fleet.Status()["eu-1"]
+	require.False(t, status.Running)
+	require.False(t, status.Ready)
+	require.True(t, fleet.Ready("us-1"))
+
+	// Removing a running server stops it first
+	require.NoError(t, fleet.Remove("us-1"))
+	require.Eventually(t, func() bool { return us.Connections() == 0 }, 2*time.Second, 5*time.Millisecond)
+	require.Equal(t, []string{"eu-1"}, fleet.Names())
+	require.ErrorIs(t, fleet.Remove("us-1"), rcon.ErrUnknownServer)
+
+	_, ok := fleet.Server("us-1")
+	require.False(t, ok)
+}
+
+func TestFleetRemoveWhileStarting(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	fleet := rcon.NewFleet()
+	require.NoError(t, fleet.Add("eu-1", newServer(t, testPassword, ts.Addr)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		for i := 0; i < 50; i++ {
+			if fleet.Start(ctx, "eu-1") != nil {
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, fleet.Remove("eu-1"))
+	<-started
+
+	// Nothing is left running behind the Fleet's back
+	require.Empty(t, fleet.Names())
+	require.Eventually(t, func() bool { return ts.Connections() == 0 }, 2*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,102 @@
+package rcon
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// linkRole is what an RCON connection is used for
+type linkRole int
+
+const (
+	// linkShared carries both commands and the event stream, the default
+	linkShared linkRole = iota
+
+	// linkCommands only carries commands, see WithSplitConnections
+	linkCommands
+
+	// linkStream only carries the event stream, see WithSplitConnections
+	linkStream
+)
+
+func (s linkRole) String() string {
+	switch s {
+	case linkShared:
+		return ConnectionShared
+	case linkCommands:
+		return ConnectionCommands
+	case linkStream:
+		return ConnectionStream
+	default:
+		return "unknown"
+	}
+}
+
+// commands reports if the Dispatcher is bound to this connection
+func (s linkRole) commands() bool { return s != linkStream }
+
+// stream reports if game logs and DevBot messages are taken from this connection
+func (s linkRole) stream() bool { return s != linkCommands }
+
+// commandRole is the role of the connection the Dispatcher is bound to
+func (s *Server) commandRole() linkRole {
+	if s.split {
+		return linkCommands
+	}
+
+	return linkShared
+}
+
+// streamRole is the role of the connection subscribed to the event stream
+func (s *Server) streamRole() linkRole {
+	if s.split {
+		return linkStream
+	}
+
+	return linkShared
+}
+
+// StreamState returns where the connection subscribed to the event stream is in its lifecycle.
+// It's the same as State unless WithSplitConnections is used
+func (s *Server) StreamState() ConnState {
+	return s.linkState(s.streamRole())
+}
+
+func (s *Server) linkState(role linkRole) ConnState {
+	if role == linkStream {
+		return ConnState(s.streamState.Load())
+	}
+
+	return s.State()
+}
+
+// setLinkState moves the connection used for role to the state to. State follows the connection
+// the Dispatcher is bound to, StreamState the one subscribed to the event stream
+func (s *Server) setLinkState(role linkRole, to ConnState) {
+	state := &s.state
+	if role == linkStream {
+		state = &s.streamState
+	}
+
+	from := ConnState(state.Swap(int32(to)))
+	if from == to {
+		return
+	}
+
+	s.logger.Debug("RCON connection state changed", "connection", role.String(), "from", from.String(), "to", to.String())
+	s.metrics.ConnStateChanged(role.String(), from, to)
+	s.stateChanges.publish(StateChange{
+		Connection: role.String(),
+		From:       from,
+		To:         to,
+		At:         time.Now(),
+	})
+}
+
+func (s *Server) linkSession(role linkRole) *atomic.Pointer[session] {
+	if role == linkStream {
+		return &s.streamSess
+	}
+
+	return &s.sess
+}
@@ -0,0 +1,25 @@
+package rcon
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardLogger is used until a logger is configured so the library is silent by default
+var discardLogger = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (s discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return s }
+func (s discardHandler) WithGroup(string) slog.Handler           { return s }
+
+// loggerOrDiscard returns l, or a logger discarding everything if l is nil
+func loggerOrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discardLogger
+	}
+
+	return l
+}
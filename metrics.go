@@ -0,0 +1,45 @@
+package rcon
+
+import (
+	"time"
+
+	"github.com/tankbusta/renx-rcon/commands"
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+// Metrics receives measurements from a single Server and its Dispatcher.
+// Implementations must be safe for concurrent use, see the metrics package for a Prometheus collector
+type Metrics interface {
+	commands.Metrics
+
+	// ConnStateChanged is called on every ConnState transition of the connection named connection,
+	// one of the Connection constants
+	ConnStateChanged(connection string, from, to ConnState)
+
+	// Reconnected is called every time a lost connection is re-established
+	Reconnected()
+
+	// LineRead is called for every line received, size includes the trailing newline
+	LineRead(size int)
+
+	// LineWritten is called for every line sent, size includes the trailing newline
+	LineWritten(size int)
+
+	// ServerError is called for every `e` message received
+	ServerError(err events.ServerError)
+
+	// LogParsed is called for every game log received. err is set if it couldn't be parsed
+	LogParsed(typ events.LogType, err error)
+}
+
+// noopMetrics is used until WithMetrics is given
+type noopMetrics struct{}
+
+func (noopMetrics) CommandSent(string)                            {}
+func (noopMetrics) CommandFinished(string, time.Duration, error)  {}
+func (noopMetrics) ConnStateChanged(string, ConnState, ConnState) {}
+func (noopMetrics) Reconnected()                                  {}
+func (noopMetrics) LineRead(int)                                  {}
+func (noopMetrics) LineWritten(int)                               {}
+func (noopMetrics) ServerError(events.ServerError)                {}
+func (noopMetrics) LogParsed(events.LogType, error)               {}
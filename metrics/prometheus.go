@@ -0,0 +1,214 @@
+// Package metrics exports measurements from rcon Servers to Prometheus.
+//
+// A single Collector is registered once and shared by every Server, each labelled by name:
+//
+//	collector := metrics.NewCollector("renx")
+//	prometheus.MustRegister(collector)
+//
+//	srv, err := rcon.NewServer(password, address, rcon.WithMetrics(collector.ForServer("eu-1")))
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	rcon "github.com/tankbusta/renx-rcon"
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+// states is every ConnState exported by the connection state gauge
+var states = []rcon.ConnState{
+	rcon.StateDisconnected,
+	rcon.StateDialing,
+	rcon.StateAuthenticating,
+	rcon.StateAuthenticated,
+	rcon.StateStreaming,
+	rcon.StateClosing,
+}
+
+// errorKinds maps the sentinel errors a ServerError may be classified as to their label
+var errorKinds = []struct {
+	err   error
+	label string
+}{
+	{events.ErrBadPassword, "bad_password"},
+	{events.ErrBanned, "banned"},
+	{events.ErrTooManyConnections, "too_many_connections"},
+	{events.ErrUnknownCommand, "unknown_command"},
+	{events.ErrInvalidArguments, "invalid_arguments"},
+}
+
+// Collector holds the Prometheus metrics of every Server using it
+type Collector struct {
+	connState   *prometheus.GaugeVec
+	reconnects  *prometheus.CounterVec
+	linesIn     *prometheus.CounterVec
+	linesOut    *prometheus.CounterVec
+	bytesIn     *prometheus.CounterVec
+	bytesOut    *prometheus.CounterVec
+	commands    *prometheus.CounterVec
+	cmdLatency  *prometheus.HistogramVec
+	rconErrors  *prometheus.CounterVec
+	logMessages *prometheus.CounterVec
+}
+
+// NewCollector creates the metrics, every name is prefixed with namespace if it's not empty
+func NewCollector(namespace string) *Collector {
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{Namespace: namespace, Subsystem: "rcon", Name: name, Help: help}
+	}
+
+	return &Collector{
+		connState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts(opts("connection_state", "1 for the state each RCON connection is currently in, 0 otherwise")),
+			[]string{"server", "connection", "state"},
+		),
+		reconnects: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("reconnects_total", "Number of times a lost RCON connection was re-established")),
+			[]string{"server"},
+		),
+		linesIn: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("received_lines_total", "Number of lines received from the game server")),
+			[]string{"server"},
+		),
+		linesOut: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("sent_lines_total", "Number of lines sent to the game server")),
+			[]string{"server"},
+		),
+		bytesIn: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("received_bytes_total", "Number of bytes received from the game server")),
+			[]string{"server"},
+		),
+		bytesOut: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("sent_bytes_total", "Number of bytes sent to the game server")),
+			[]string{"server"},
+		),
+		commands: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("commands_sent_total", "Number of commands sent to the game server")),
+			[]string{"server", "command"},
+		),
+		cmdLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: "rcon",
+				Name:      "command_duration_seconds",
+				Help:      "Time from writing a command until the game server finished executing it",
+				Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+			},
+			[]string{"server", "command", "result"},
+		),
+		rconErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("errors_total", "Number of errors sent by the game server, by kind")),
+			[]string{"server", "kind"},
+		),
+		logMessages: prometheus.NewCounterVec(
+			prometheus.CounterOpts(opts("log_messages_total", "Number of game logs received, by type and if they could be parsed")),
+			[]string{"server", "type", "result"},
+		),
+	}
+}
+
+func (s *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		s.connState, s.reconnects,
+		s.linesIn, s.linesOut, s.bytesIn, s.bytesOut,
+		s.commands, s.cmdLatency,
+		s.rconErrors, s.logMessages,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (s *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range s.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (s *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range s.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// ForServer returns the rcon.Metrics for a single Server, labelled with name.
+// Connection states are exported once the connection first changed state
+func (s *Collector) ForServer(name string) rcon.Metrics {
+	return &serverMetrics{c: s, name: name}
+}
+
+type serverMetrics struct {
+	c    *Collector
+	name string
+
+	// connections are the connections whose states were exported
+	connections sync.Map
+}
+
+func (s *serverMetrics) CommandSent(name string) {
+	s.c.commands.WithLabelValues(s.name, name).Inc()
+}
+
+func (s *serverMetrics) CommandFinished(name string, latency time.Duration, err error) {
+	s.c.cmdLatency.WithLabelValues(s.name, name, result(err)).Observe(latency.Seconds())
+}
+
+func (s *serverMetrics) ConnStateChanged(connection string, from, to rcon.ConnState) {
+	// Every state is exported so dashboards don't see gaps for the ones a connection wasn't in yet
+	if _, seen := s.connections.LoadOrStore(connection, struct{}{}); !seen {
+		for _, state := range states {
+			s.c.connState.WithLabelValues(s.name, connection, state.String()).Set(0)
+		}
+	}
+
+	s.c.connState.WithLabelValues(s.name, connection, from.String()).Set(0)
+	s.c.connState.WithLabelValues(s.name, connection, to.String()).Set(1)
+}
+
+func (s *serverMetrics) Reconnected() {
+	s.c.reconnects.WithLabelValues(s.name).Inc()
+}
+
+func (s *serverMetrics) LineRead(size int) {
+	s.c.linesIn.WithLabelValues(s.name).Inc()
+	s.c.bytesIn.WithLabelValues(s.name).Add(float64(size))
+}
+
+func (s *serverMetrics) LineWritten(size int) {
+	s.c.linesOut.WithLabelValues(s.name).Inc()
+	s.c.bytesOut.WithLabelValues(s.name).Add(float64(size))
+}
+
+func (s *serverMetrics) ServerError(err events.ServerError) {
+	s.c.rconErrors.WithLabelValues(s.name, errorKind(err)).Inc()
+}
+
+func (s *serverMetrics) LogParsed(typ events.LogType, err error) {
+	if typ == "" {
+		typ = "unknown"
+	}
+
+	s.c.logMessages.WithLabelValues(s.name, string(typ), result(err)).Inc()
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "ok"
+}
+
+// errorKind returns the label for err, the raw message isn't used to keep cardinality bounded
+func errorKind(err events.ServerError) string {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.label
+		}
+	}
+
+	return "other"
+}
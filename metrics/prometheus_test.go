@@ -0,0 +1,80 @@
+package metrics_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	rcon "github.com/tankbusta/renx-rcon"
+	"github.com/tankbusta/renx-rcon/commands"
+	"github.com/tankbusta/renx-rcon/metrics"
+	"github.com/tankbusta/renx-rcon/rcontest"
+)
+
+func TestCollector(t *testing.T) {
+	ts := rcontest.NewServer("hunter2")
+	defer ts.Close()
+
+	collector := metrics.NewCollector("test")
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(collector))
+
+	svr, err := rcon.NewServer("hunter2", ts.Addr, rcon.WithMetrics(collector.ForServer("eu-1")))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svr.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, svr.Ready, 2*time.Second, 5*time.Millisecond)
+
+	execCtx, execCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer execCancel()
+
+	_, err = svr.Exec(execCtx, commands.NewKeepAliveCommand())
+	require.NoError(t, err)
+
+	_, err = svr.Exec(execCtx, commands.NewListBotsCommand())
+	require.Error(t, err)
+
+	expected := `
+# HELP test_rcon_commands_sent_total Number of commands sent to the game server
+# TYPE test_rcon_commands_sent_total counter
+test_rcon_commands_sent_total{command="BotVarList",server="eu-1"} 1
+test_rcon_commands_sent_total{command="ping",server="eu-1"} 1
+# HELP test_rcon_errors_total Number of errors sent by the game server, by kind
+# TYPE test_rcon_errors_total counter
+test_rcon_errors_total{kind="unknown_command",server="eu-1"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"test_rcon_commands_sent_total", "test_rcon_errors_total"))
+
+	gauge := `
+# HELP test_rcon_connection_state 1 for the state each RCON connection is currently in, 0 otherwise
+# TYPE test_rcon_connection_state gauge
+test_rcon_connection_state{connection="shared",server="eu-1",state="Authenticated"} 0
+test_rcon_connection_state{connection="shared",server="eu-1",state="Authenticating"} 0
+test_rcon_connection_state{connection="shared",server="eu-1",state="Closing"} 0
+test_rcon_connection_state{connection="shared",server="eu-1",state="Dialing"} 0
+test_rcon_connection_state{connection="shared",server="eu-1",state="Disconnected"} 0
+test_rcon_connection_state{connection="shared",server="eu-1",state="Streaming"} 1
+`
+	require.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(reg, strings.NewReader(gauge), "test_rcon_connection_state") == nil
+	}, 2*time.Second, 5*time.Millisecond)
+
+	count, err := testutil.GatherAndCount(reg, "test_rcon_command_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
@@ -0,0 +1,376 @@
+package rcon
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/tankbusta/renx-rcon/capture"
+	"github.com/tankbusta/renx-rcon/commands"
+	"github.com/tankbusta/renx-rcon/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultDialTimeout bounds how long opening a connection may take
+	DefaultDialTimeout = 10 * time.Second
+
+	// DefaultReadTimeout is how long the connection may go without receiving anything before it's
+	// considered dead. Each keepalive is answered so a healthy connection never gets close to it
+	DefaultReadTimeout = 90 * time.Second
+
+	// DefaultWriteTimeout bounds how long writing a single line may take
+	DefaultWriteTimeout = 2 * time.Second
+
+	// DefaultStatePollInterval is how often the GameStateManager refreshes its state
+	DefaultStatePollInterval = 5 * time.Second
+
+	// DefaultMaxLineLength is the longest line we accept from the game server
+	DefaultMaxLineLength = events.DefaultMaxLineLength
+
+	// DefaultCommandTimeout is how long a command may take, from being queued until the game
+	// server finished executing it, before it fails with commands.ErrCommandTimeout
+	DefaultCommandTimeout = 30 * time.Second
+
+	// DefaultQueueLimit is how many commands may wait to be written before new ones are
+	// rejected with commands.ErrQueueFull, see WithQueueLimit
+	DefaultQueueLimit = 1000
+
+	// DefaultShutdownTimeout bounds how long Shutdown waits for queued commands and the connection to close
+	DefaultShutdownTimeout = 10 * time.Second
+)
+
+// Option configures a Server created by NewServer
+type Option func(*Server) error
+
+// WithDialTimeout bounds how long opening a connection may take. Zero disables the timeout
+func WithDialTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("dial timeout must not be negative")
+		}
+
+		s.dialTimeout = d
+		return nil
+	}
+}
+
+// WithReadTimeout sets how long the connection may go without receiving anything before it's
+// considered dead and re-established. Zero disables the timeout
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("read timeout must not be negative")
+		}
+
+		s.readTimeout = d
+		return nil
+	}
+}
+
+// WithWriteTimeout bounds how long writing a single line may take. Zero disables the timeout
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("write timeout must not be negative")
+		}
+
+		s.writeTimeout = d
+		return nil
+	}
+}
+
+// WithStatePollInterval sets how often the GameStateManager refreshes its state
+func WithStatePollInterval(d time.Duration) Option {
+	return func(s *Server) error {
+		if d <= 0 {
+			return errors.New("state poll interval must be positive")
+		}
+
+		s.pollInterval = d
+		return nil
+	}
+}
+
+// WithLogger sets where the server and its GameStateManager log to. Nothing is logged unless a logger is set
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) error {
+		s.logger = slog.New(redactingHandler{next: loggerOrDiscard(l).Handler(), r: s.secrets}).With("address", s.Address)
+		return nil
+	}
+}
+
+// WithDialer changes how connections to the game server are opened
+func WithDialer(d Dialer) Option {
+	return func(s *Server) error {
+		if d == nil {
+			return errors.New("dialer must not be nil")
+		}
+
+		s.dialer = d
+		return nil
+	}
+}
+
+// WithNetwork changes the network passed to the Dialer, `tcp` by default.
+// Use `unix` with a socket path as the address to reach a local relay
+func WithNetwork(network string) Option {
+	return func(s *Server) error {
+		if network == "" {
+			return errors.New("network must not be empty")
+		}
+
+		s.network = network
+		return nil
+	}
+}
+
+// WithKeepAlive changes how long the connection may be idle before a keepalive command is sent.
+// Zero disables the keepalive
+func WithKeepAlive(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval < 0 {
+			return errors.New("keepalive interval must not be negative")
+		}
+
+		s.keepAlive = interval
+		return nil
+	}
+}
+
+// WithMaxLineLength sets the longest line accepted from the game server. Longer lines are discarded
+func WithMaxLineLength(n int) Option {
+	return func(s *Server) error {
+		// bufio never buffers less than 16 bytes
+		if n < 16 {
+			return errors.New("max line length must be at least 16")
+		}
+
+		s.maxLineLength = n
+		return nil
+	}
+}
+
+// WithReconnectPolicy changes how the server re-establishes lost connections
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(s *Server) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+
+		s.reconnect = policy
+		return nil
+	}
+}
+
+// WithCapture tees every line sent and received into w. The RCON password is never captured
+// and w is not closed by the server
+func WithCapture(w *capture.Writer) Option {
+	return func(s *Server) error {
+		s.capture = w
+		return nil
+	}
+}
+
+// WithStreamEvents controls if the server subscribes to the game server's event stream right
+// after authenticating. It defaults to true, disable it for command-only sessions
+func WithStreamEvents(enabled bool) Option {
+	return func(s *Server) error {
+		s.streamEvents = enabled
+		return nil
+	}
+}
+
+// WithShutdownTimeout bounds how long Shutdown may take on top of the context given to it.
+// Zero leaves it up to the context
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("shutdown timeout must not be negative")
+		}
+
+		s.shutdownTimeout = d
+		return nil
+	}
+}
+
+// WithRateLimit limits how fast commands are written to the game server with a token bucket
+// refilled at limit commands per second that holds up to burst commands. Commands wait in the
+// queue for their turn, use WithQueueLimit to bound how many may pile up
+func WithRateLimit(limit rate.Limit, burst int) Option {
+	return func(s *Server) error {
+		if limit <= 0 || burst < 1 {
+			return errors.New("rate limit and burst must be positive")
+		}
+
+		s.limiter = rate.NewLimiter(limit, burst)
+		return nil
+	}
+}
+
+// WithQueueLimit bounds the number of commands waiting to be written to size,
+// policy decides what happens to new commands once it's full. By default DefaultQueueLimit
+// commands may wait and further ones are rejected
+func WithQueueLimit(size int, policy commands.QueuePolicy) Option {
+	return func(s *Server) error {
+		if size < 1 {
+			return errors.New("queue limit must be positive")
+		}
+
+		switch policy {
+		case commands.QueueBlock, commands.QueueReject, commands.QueueDropOldest:
+		default:
+			return fmt.Errorf("unknown queue policy %d", policy)
+		}
+
+		s.cmdWriter.SetQueueLimit(size, policy)
+		return nil
+	}
+}
+
+// WithCredentials takes the RCON password from p instead of the one given to NewServer.
+// p is asked on every connect, so rotating the password only requires a reconnect. Wrap p with
+// RotatingCredentials to keep retrying a password the game server rejects
+func WithCredentials(p CredentialProvider) Option {
+	return func(s *Server) error {
+		if p == nil {
+			return errors.New("credential provider must not be nil")
+		}
+
+		s.credentials = p
+		return nil
+	}
+}
+
+// WithSensitiveCommands marks the arguments of the named commands as secret, such as a password
+// given to a command. Once sent they're redacted from logs, captures and metrics like the RCON password
+func WithSensitiveCommands(names ...string) Option {
+	return func(s *Server) error {
+		for _, name := range names {
+			s.secrets.addSensitive(name)
+		}
+
+		return nil
+	}
+}
+
+// WithAddresses adds addresses of the same game server, tried in order after the one given to
+// NewServer. When dialing or authenticating at one fails we fail over to the next, see ActiveAddress
+func WithAddresses(addrs ...string) Option {
+	return func(s *Server) error {
+		for _, addr := range addrs {
+			if addr == "" {
+				return errors.New("addresses must not be empty")
+			}
+		}
+
+		s.fallbacks = append(s.fallbacks, addrs...)
+		return nil
+	}
+}
+
+// WithSRV looks up the SRV record _service._proto.name before every connect and tries its targets,
+// in priority order, ahead of the other addresses. They're used on their own if the lookup fails
+func WithSRV(service, proto, name string) Option {
+	return func(s *Server) error {
+		if name == "" {
+			return errors.New("SRV name must not be empty")
+		}
+
+		s.srv = &srvRecord{service: service, proto: proto, name: name}
+		return nil
+	}
+}
+
+// WithSplitConnections opens two connections to the game server: one subscribed to the event
+// stream and one for commands, so neither a flood of game logs nor a long command response
+// holds up the other. Each is re-established on its own. State and Ready follow the command
+// connection, StreamState the event stream one
+func WithSplitConnections(enabled bool) Option {
+	return func(s *Server) error {
+		s.split = enabled
+		return nil
+	}
+}
+
+// WithCommandTimeout changes how long a command may take, from being queued until the game server
+// finished executing it, before it fails with commands.ErrCommandTimeout. Zero lets commands wait forever.
+//
+// The game server doesn't tag responses with the command they belong to. Anything it still sends
+// for a timed out command before the next one is written is discarded, anything after that is
+// taken as the response to the next one
+func WithCommandTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("command timeout must not be negative")
+		}
+
+		s.cmdWriter.SetTimeout(d)
+		return nil
+	}
+}
+
+// WithCommandTimeoutFor overrides the command timeout for the command named name, such as a
+// longer one for `BotVarList` on a full server. Zero lets it wait forever
+func WithCommandTimeoutFor(name string, d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("command timeout must not be negative")
+		}
+
+		s.cmdWriter.SetCommandTimeout(name, d)
+		return nil
+	}
+}
+
+// WithMetrics reports measurements about the connection, commands and event pipeline to m
+func WithMetrics(m Metrics) Option {
+	return func(s *Server) error {
+		if m == nil {
+			return errors.New("metrics must not be nil")
+		}
+
+		s.metrics = m
+		s.cmdWriter.SetMetrics(m)
+		return nil
+	}
+}
+
+// WithTracerProvider creates an OpenTelemetry span for every command, from being queued until
+// the game server finished executing it. Use WriteMsgContext or Exec to parent them to a caller's span
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Server) error {
+		if tp == nil {
+			return errors.New("tracer provider must not be nil")
+		}
+
+		s.cmdWriter.SetTracer(
+			tp.Tracer("github.com/tankbusta/renx-rcon"),
+			attribute.String("server.address", s.Address),
+		)
+		return nil
+	}
+}
+
+// validate checks the combination of options makes sense
+func (s *Server) validate() error {
+	if s.Address == "" {
+		return errors.New("game server address must not be empty")
+	}
+
+	if password, ok := s.credentials.(staticPassword); ok && password == "" {
+		return errors.New("RCON password must not be empty unless WithCredentials is used")
+	}
+
+	if s.readTimeout > 0 && s.keepAlive > 0 && s.readTimeout <= s.keepAlive {
+		return fmt.Errorf(
+			"read timeout (%s) must be longer than the keepalive interval (%s)",
+			s.readTimeout, s.keepAlive,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,407 @@
+// Package rcontest provides an in-process fake Renegade X RCON server for tests.
+//
+// It speaks enough of the protocol to exercise a client end to end: it sends the version line,
+// checks the password, answers commands with scripted responses, streams log lines to subscribed
+// connections and can simulate errors and dropped connections.
+package rcontest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+// DefaultVersion is sent as the `v` line to every new connection
+var DefaultVersion = strings.Join([]string{"3", "5887", "Renegade X Open Beta 5.887"}, string(events.Delimiter))
+
+// Response is the scripted answer to a command
+type Response struct {
+	// Header is sent as the first `r` line if set
+	Header string
+
+	// Rows are sent as `r` lines after the header
+	Rows []string
+
+	// Err is sent as an `e` line instead of any rows if set, followed by `c` like the game server does
+	Err string
+
+	// Disconnect closes the connection instead of answering
+	Disconnect bool
+
+	// Silent sends nothing at all, as if the game server never finished the command
+	Silent bool
+}
+
+// HandlerFunc builds the response to a command from its arguments
+type HandlerFunc func(args string) Response
+
+// Server is a fake RCON server listening on a local TCP port
+type Server struct {
+	// Addr is the host:port the server listens on
+	Addr string
+
+	// Password clients must authenticate with
+	Password string
+
+	// Version is the body of the `v` line sent on connect
+	Version string
+
+	// unexported fields below
+	listener net.Listener
+	handlers map[string]HandlerFunc
+	authErr  string
+	conns    map[*conn]struct{}
+	received []string
+	nextID   int
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+type conn struct {
+	net.Conn
+
+	authenticated bool
+	subscribed    bool
+
+	// out is drained by writeLoop so answering never blocks on a client that's busy writing,
+	// which matters for unbuffered transports such as net.Pipe
+	out  chan []byte
+	done chan struct{}
+}
+
+func (s *conn) send(msgType events.ServerType, body string) {
+	select {
+	case s.out <- []byte(string(msgType) + body + "\n"):
+	case <-s.done:
+	}
+}
+
+// reject writes an error directly, bypassing out, since the connection is closed right after
+func (s *conn) reject(msg string) {
+	s.Write([]byte(string(events.Error) + msg + "\n"))
+}
+
+func (s *conn) writeLoop() {
+	for {
+		select {
+		case msg := <-s.out:
+			if _, err := s.Write(msg); err != nil {
+				s.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// NewServer starts a fake RCON server on a random local port.
+// Unless scripted otherwise, `ping` is answered with `pong` and every other command with an error
+func NewServer(password string) *Server {
+	s := NewUnstartedServer(password)
+	s.Start()
+
+	return s
+}
+
+// NewUnstartedServer returns a fake RCON server that doesn't accept connections until Start is called
+func NewUnstartedServer(password string) *Server {
+	s := &Server{
+		Password: password,
+		Version:  DefaultVersion,
+		handlers: make(map[string]HandlerFunc),
+		conns:    make(map[*conn]struct{}),
+	}
+
+	s.Handle("ping", Response{Rows: []string{"pong"}})
+	return s
+}
+
+// Start listening on a random local port
+func (s *Server) Start() {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("rcontest: failed to listen: %s", err))
+	}
+
+	s.listener = ln
+	s.Addr = ln.Addr().String()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.ServeConn(c)
+			}()
+		}
+	}()
+}
+
+// Close stops listening, drops every connection and waits for them to finish
+func (s *Server) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	s.Disconnect()
+	s.wg.Wait()
+}
+
+// Handle scripts the response to command, matched case insensitively against the first word after `c`
+func (s *Server) Handle(command string, resp Response) {
+	s.HandleFunc(command, func(string) Response { return resp })
+}
+
+// HandleFunc scripts a dynamic response to command
+func (s *Server) HandleFunc(command string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[strings.ToLower(command)] = fn
+}
+
+// RejectAuth makes the server answer every authentication attempt with msg as an error.
+// An empty msg restores normal password checking
+func (s *Server) RejectAuth(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.authErr = msg
+}
+
+// EmitLog sends a `l` line to every subscribed connection
+func (s *Server) EmitLog(line string) {
+	s.broadcast(events.GameLog, line, func(c *conn) bool { return c.subscribed })
+}
+
+// EmitDevBot sends a `d` line to every authenticated connection
+func (s *Server) EmitDevBot(line string) {
+	s.broadcast(events.ServerDevBot, line, func(c *conn) bool { return c.authenticated })
+}
+
+// Send writes a raw message to every authenticated connection
+func (s *Server) Send(msgType events.ServerType, body string) {
+	s.broadcast(msgType, body, func(c *conn) bool { return c.authenticated })
+}
+
+func (s *Server) broadcast(msgType events.ServerType, body string, match func(*conn) bool) {
+	s.mu.Lock()
+	targets := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		if match(c) {
+			targets = append(targets, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		c.send(msgType, body)
+	}
+}
+
+// Disconnect drops every open connection
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Connections returns the number of open connections
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// Subscribed returns the number of connections subscribed to the event stream
+func (s *Server) Subscribed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for c := range s.conns {
+		if c.subscribed {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Received returns every line received from clients so far, without the trailing newline
+func (s *Server) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.received...)
+}
+
+// Commands returns the name of every command received so far, in order
+func (s *Server) Commands() []string {
+	var out []string
+
+	for _, line := range s.Received() {
+		if strings.HasPrefix(line, string(events.Command)) {
+			name, _, _ := strings.Cut(line[1:], " ")
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+// ServeConn speaks the RCON protocol on c until it's closed.
+// It can be used with net.Pipe to test without a TCP listener
+func (s *Server) ServeConn(nc net.Conn) {
+	c := &conn{
+		Conn: nc,
+		out:  make(chan []byte, 64),
+		done: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.nextID++
+	connID := strconv.Itoa(s.nextID)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+
+		close(c.done)
+		c.Close()
+	}()
+
+	go c.writeLoop()
+	c.send(events.RCONGameVersion, s.Version)
+
+	rdr := bufio.NewReader(c)
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, line)
+		s.mu.Unlock()
+
+		if !s.handleLine(c, connID, line) {
+			return
+		}
+	}
+}
+
+// handleLine answers a single client line. It returns false once the connection should be closed
+func (s *Server) handleLine(c *conn, connID, line string) bool {
+	body := line[1:]
+
+	switch events.ClientType(line[0]) {
+	case events.Authenticate:
+		s.mu.Lock()
+		authErr := s.authErr
+		s.mu.Unlock()
+
+		switch {
+		case authErr != "":
+			c.reject(authErr)
+			return false
+		case body != s.Password:
+			c.reject("Invalid password")
+			return false
+		}
+
+		s.mu.Lock()
+		c.authenticated = true
+		s.mu.Unlock()
+
+		c.send(events.AuthenticationSuccess, connID)
+		return true
+	}
+
+	s.mu.Lock()
+	authenticated := c.authenticated
+	s.mu.Unlock()
+
+	if !authenticated {
+		c.send(events.Error, "Not authenticated")
+		return true
+	}
+
+	switch events.ClientType(line[0]) {
+	case events.Subscribe, events.UnSubscribe:
+		s.mu.Lock()
+		c.subscribed = events.ClientType(line[0]) == events.Subscribe
+		s.mu.Unlock()
+	case events.Command:
+		return s.handleCommand(c, body)
+	case events.DevBot:
+		// Recorded in Received, nothing to answer
+	default:
+		c.send(events.Error, "Unknown message type")
+	}
+
+	return true
+}
+
+func (s *Server) handleCommand(c *conn, body string) bool {
+	name, args, _ := strings.Cut(body, " ")
+
+	s.mu.Lock()
+	handler, ok := s.handlers[strings.ToLower(name)]
+	s.mu.Unlock()
+
+	if !ok {
+		c.send(events.Error, "Unknown command: "+name)
+		c.send(events.CommandExecutionFinished, "")
+		return true
+	}
+
+	resp := handler(args)
+	switch {
+	case resp.Disconnect:
+		return false
+	case resp.Silent:
+		return true
+	case resp.Err != "":
+		c.send(events.Error, resp.Err)
+		c.send(events.CommandExecutionFinished, "")
+		return true
+	}
+
+	if resp.Header != "" {
+		c.send(events.CommandResponse, resp.Header)
+	}
+
+	for _, row := range resp.Rows {
+		c.send(events.CommandResponse, row)
+	}
+
+	c.send(events.CommandExecutionFinished, "")
+	return true
+}
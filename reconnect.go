@@ -0,0 +1,108 @@
+package rcon
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ReconnectPolicy controls how a Server re-establishes its RCON connection
+// after it has been lost or could not be established.
+//
+// Delays grow exponentially from InitialInterval by Multiplier up to MaxInterval,
+// with each delay randomized by +/- Jitter to avoid many bots reconnecting in lockstep.
+type ReconnectPolicy struct {
+	// InitialInterval is the delay before the first reconnect attempt
+	InitialInterval time.Duration
+
+	// MaxInterval caps the delay between two reconnect attempts
+	MaxInterval time.Duration
+
+	// Multiplier is applied to the delay after every failed attempt. Zero defaults to 2
+	Multiplier float64
+
+	// Jitter is the randomization factor (0 to 1) applied to each delay
+	Jitter float64
+
+	// MaxAttempts is the number of consecutive failed attempts before giving up.
+	// Zero means retry forever and a negative value disables reconnecting entirely
+	MaxAttempts int
+
+	// MaxElapsedTime is how long we keep retrying since the link was lost.
+	// Zero means retry forever
+	MaxElapsedTime time.Duration
+}
+
+// DefaultReconnectPolicy retries forever, backing off from 1 second up to 1 minute
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialInterval: time.Second,
+	MaxInterval:     time.Minute,
+	Multiplier:      2,
+	Jitter:          0.2,
+}
+
+// NoReconnect makes Server.Start return the first connection error
+var NoReconnect = ReconnectPolicy{MaxAttempts: -1}
+
+// Delay returns how long to wait before the given attempt, with attempt starting at 1
+func (s ReconnectPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	multiplier := s.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	delay := float64(s.InitialInterval)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if s.MaxInterval > 0 && delay >= float64(s.MaxInterval) {
+			delay = float64(s.MaxInterval)
+			break
+		}
+	}
+
+	if s.Jitter > 0 {
+		delta := s.Jitter * delay
+		delay = delay - delta + rand.Float64()*(2*delta)
+	}
+
+	if s.MaxInterval > 0 && delay > float64(s.MaxInterval) {
+		delay = float64(s.MaxInterval)
+	}
+
+	return time.Duration(delay)
+}
+
+func (s ReconnectPolicy) validate() error {
+	switch {
+	case s.MaxAttempts < 0:
+		return nil // Reconnecting is disabled, nothing else matters
+	case s.InitialInterval <= 0:
+		return errors.New("reconnect initial interval must be positive")
+	case s.MaxInterval < 0 || s.MaxElapsedTime < 0:
+		return errors.New("reconnect intervals must not be negative")
+	case s.Multiplier != 0 && s.Multiplier < 1:
+		return errors.New("reconnect multiplier must be at least 1")
+	case s.Jitter < 0 || s.Jitter > 1:
+		return errors.New("reconnect jitter must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// exhausted reports if we should stop retrying after `attempts` failures since `lostAt`
+func (s ReconnectPolicy) exhausted(attempts int, lostAt time.Time) bool {
+	switch {
+	case s.MaxAttempts < 0:
+		return true
+	case s.MaxAttempts > 0 && attempts >= s.MaxAttempts:
+		return true
+	case s.MaxElapsedTime > 0 && time.Since(lostAt) >= s.MaxElapsedTime:
+		return true
+	}
+
+	return false
+}
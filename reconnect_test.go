@@ -0,0 +1,47 @@
+package rcon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	policy := ReconnectPolicy{InitialInterval: 10 * time.Millisecond, MaxInterval: 50 * time.Millisecond}
+	require.NoError(t, policy.validate())
+
+	var delays []time.Duration
+	for attempt := 0; attempt <= 5; attempt++ {
+		delays = append(delays, policy.Delay(attempt))
+	}
+
+	// A zero multiplier backs off like the default one instead of retrying in a hot loop
+	require.Equal(t, []time.Duration{
+		10 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+		40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond,
+	}, delays)
+
+	policy.Multiplier = 1
+	require.Equal(t, 10*time.Millisecond, policy.Delay(5))
+
+	policy.Jitter = 0.5
+	for attempt := 1; attempt <= 10; attempt++ {
+		require.InDelta(t, float64(10*time.Millisecond), float64(policy.Delay(attempt)), float64(5*time.Millisecond))
+	}
+}
+
+func TestReconnectPolicyExhausted(t *testing.T) {
+	now := time.Now()
+
+	require.True(t, NoReconnect.exhausted(1, now))
+	require.False(t, DefaultReconnectPolicy.exhausted(1000, now.Add(-time.Hour)), "the default retries forever")
+
+	attempts := ReconnectPolicy{InitialInterval: time.Second, MaxAttempts: 3}
+	require.False(t, attempts.exhausted(2, now))
+	require.True(t, attempts.exhausted(3, now))
+
+	elapsed := ReconnectPolicy{InitialInterval: time.Second, MaxElapsedTime: time.Minute}
+	require.False(t, elapsed.exhausted(100, now.Add(-30*time.Second)))
+	require.True(t, elapsed.exhausted(1, now.Add(-time.Minute)))
+}
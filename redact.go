@@ -0,0 +1,158 @@
+package rcon
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/tankbusta/renx-rcon/capture"
+)
+
+// maxSecrets bounds how many arguments of sensitive commands a redactor remembers, the oldest
+// are forgotten first. RCON passwords are never forgotten
+const maxSecrets = 64
+
+// redactor replaces every secret we've sent, the RCON password and the arguments of
+// sensitive commands, wherever it shows up
+type redactor struct {
+	credentials map[string]struct{}
+	secrets     []string
+	sensitive   map[string]struct{}
+	mu          sync.RWMutex
+}
+
+func newRedactor() *redactor {
+	return &redactor{
+		credentials: make(map[string]struct{}),
+		sensitive:   make(map[string]struct{}),
+	}
+}
+
+// addCredential redacts an RCON password for as long as the redactor is around. Only rotations
+// add more than one, so unlike addSecret there's no bound
+func (s *redactor) addCredential(password string) {
+	if password == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.credentials[password] = struct{}{}
+}
+
+// addSensitive marks the arguments of command as secret
+func (s *redactor) addSensitive(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sensitive[strings.ToLower(command)] = struct{}{}
+}
+
+func (s *redactor) isSensitive(command string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.sensitive[strings.ToLower(command)]
+	return ok
+}
+
+// addSecret redacts secret from now on, until maxSecrets newer ones were added
+func (s *redactor) addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, known := range s.secrets {
+		if known == secret {
+			return
+		}
+	}
+
+	if len(s.secrets) == maxSecrets {
+		s.secrets = s.secrets[1:]
+	}
+	s.secrets = append(s.secrets, secret)
+}
+
+// redact replaces every secret in str
+func (s *redactor) redact(str string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for password := range s.credentials {
+		if strings.Contains(str, password) {
+			str = strings.ReplaceAll(str, password, capture.Redacted)
+		}
+	}
+
+	for _, secret := range s.secrets {
+		if strings.Contains(str, secret) {
+			str = strings.ReplaceAll(str, secret, capture.Redacted)
+		}
+	}
+
+	return str
+}
+
+// redactingHandler redacts the message and every attribute of a log record before passing it on
+type redactingHandler struct {
+	next slog.Handler
+	r    *redactor
+}
+
+func (s redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return s.next.Enabled(ctx, level)
+}
+
+func (s redactingHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, s.r.redact(rec.Message), rec.PC)
+	rec.Attrs(func(attr slog.Attr) bool {
+		out.AddAttrs(s.attr(attr))
+		return true
+	})
+
+	return s.next.Handle(ctx, out)
+}
+
+func (s redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = s.attr(attr)
+	}
+
+	return redactingHandler{next: s.next.WithAttrs(redacted), r: s.r}
+}
+
+func (s redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{next: s.next.WithGroup(name), r: s.r}
+}
+
+func (s redactingHandler) attr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(s.r.redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = s.attr(member)
+		}
+		attr.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		// Errors and anything else printable may embed what we sent
+		if err, ok := value.Any().(error); ok {
+			attr.Value = slog.StringValue(s.r.redact(err.Error()))
+		} else if str, ok := value.Any().(interface{ String() string }); ok {
+			attr.Value = slog.StringValue(s.r.redact(str.String()))
+		}
+	}
+
+	return attr
+}
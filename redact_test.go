@@ -0,0 +1,21 @@
+package rcon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactorKeepsCredentials(t *testing.T) {
+	r := newRedactor()
+	r.addCredential("hunter2")
+
+	for i := 0; i <= maxSecrets; i++ {
+		r.addSecret(fmt.Sprintf("[argument-%d]", i))
+	}
+
+	require.Equal(t, "a<redacted>", r.redact("ahunter2"), "the password outlives any number of sensitive arguments")
+	require.Equal(t, "<redacted>", r.redact(fmt.Sprintf("[argument-%d]", maxSecrets)))
+	require.Equal(t, "[argument-0]", r.redact("[argument-0]"), "the oldest argument was forgotten")
+}
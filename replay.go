@@ -0,0 +1,97 @@
+package rcon
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/tankbusta/renx-rcon/capture"
+	"github.com/tankbusta/renx-rcon/commands"
+)
+
+// ReplayAddress is the Address of a Server playing back a capture
+const ReplayAddress = "replay"
+
+// replayable are the commands whose responses we know how to handle, keyed by lower case name
+var replayable = map[string]commands.ICommand{
+	"botvarlist": commands.NewListBotsCommand(),
+	"serverinfo": commands.NewServerInfoCommand(),
+	"ping":       commands.NewKeepAliveCommand(),
+}
+
+// NewReplayServer returns a Server that plays back the capture read from r instead of
+// connecting to a game server. Every line the game server sent goes through the same pipeline
+// as a live connection, and Start returns once the capture has been played back.
+//
+// The GameStateManager doesn't poll on its own, instead every command the original session sent
+// is queued again when the capture reaches it and its responses are handed to it, so the state
+// is rebuilt exactly as it was. The keepalive and read timeout are disabled
+func NewReplayServer(r io.Reader, replayOpts capture.ReplayOptions, opts ...Option) (*Server, error) {
+	var (
+		once sync.Once
+		svr  *Server
+	)
+
+	replayOpts.Command = func(line string) error { return svr.replayCommand(line) }
+
+	dialer := DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn := net.Conn(nil)
+		once.Do(func() {
+			conn = capture.NewReplayConn(capture.NewReader(r), replayOpts)
+		})
+
+		if conn == nil {
+			return nil, capture.ErrEndOfReplay
+		}
+
+		return conn, nil
+	})
+
+	// The replayed game server never checks the password
+	svr, err := NewServer(capture.Redacted, ReplayAddress, append([]Option{
+		WithDialer(dialer),
+		WithKeepAlive(0),
+		WithReadTimeout(0),
+		WithReconnectPolicy(NoReconnect),
+	}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	svr.pollInterval = 0
+	return svr, nil
+}
+
+// replayCommand queues a command recorded in a capture, written exactly as it was recorded
+func (s *Server) replayCommand(line string) error {
+	name, _, _ := strings.Cut(strings.TrimSuffix(line[1:], "\n"), " ")
+
+	cmd := replayable[strings.ToLower(name)]
+	if cmd == nil {
+		cmd = rawCommand{name: name}
+	}
+	cmd = replayedCommand{ICommand: cmd, line: line}
+
+	return s.WriteMsg(cmd, s.GameState.handlerFor(cmd))
+}
+
+// replayedCommand is a command marshalled to the line it was recorded as
+type replayedCommand struct {
+	commands.ICommand
+
+	line string
+}
+
+func (s replayedCommand) MarshalRCON() []byte { return []byte(s.line) }
+
+// rawCommand stands in for a recorded command we don't know
+type rawCommand struct {
+	name string
+}
+
+func (s rawCommand) Command() string                 { return s.name }
+func (s rawCommand) MarshalRCON() []byte             { return []byte("c" + s.name + "\n") }
+func (s rawCommand) UnmarshalRCON(string, any) error { return nil }
+func (s rawCommand) SkipFirstMsg() bool              { return false }
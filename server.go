@@ -1,207 +1,479 @@
 package rcon
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/tankbusta/renx-rcon/capture"
 	"github.com/tankbusta/renx-rcon/commands"
 	"github.com/tankbusta/renx-rcon/events"
 	"github.com/tankbusta/renx-rcon/games"
+	"golang.org/x/time/rate"
 )
 
 const WriterSizeQueue = 10
 
-type Server struct {
-	// Game indicates which Totem Arts game this server is running
-	Game games.Game
+// DefaultKeepAlive is how long the connection may be idle before a keepalive command is sent.
+// The game server drops RCON clients that haven't sent anything in 60 seconds
+const DefaultKeepAlive = 30 * time.Second
 
+type Server struct {
 	// Address of the Game Server
 	Address string
 
-	// ConnectionID of the RCON connection
-	ConnectionID string
-
-	// Version of the UDK Game Server
-	Version events.Version
-
-	// IsAuthenticated marks if the server has accepted our RCON credentials
-	IsAuthenticated bool
-
-	// IsConnected marks if the server has been connected to (but not necessairly authenticated)
-	IsConnected bool
-
+	// GameState is the GameStateManager kept up to date by Start, nil until Start is called
 	GameState *GameStateManager
 
 	// unexported fields below
 	cmdWriter    *commands.Dispatcher
-	rconPassword string
+	info         serverInfo
+	infoMu       sync.RWMutex
+	logger       *slog.Logger
+	events       *eventHub[Event]
+	state        atomic.Int32
+	stateChanges *eventHub[StateChange]
+	sess         atomic.Pointer[session]
+	fallbacks    []string
+	srv          *srvRecord
+	resolved     []string
+	active       string
+	addrMu       sync.Mutex
+	streamSess   atomic.Pointer[session]
+	streamState  atomic.Int32
+	split        bool
+	streamEvents bool
+	streamMu     sync.Mutex
+	keepAlive    time.Duration
+	capture      *capture.Writer
+	dialer       Dialer
+	network      string
+	limiter      *rate.Limiter
+	metrics      Metrics
+
+	dialTimeout   time.Duration
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	pollInterval  time.Duration
+	maxLineLength int
+
+	// stop and stopped are set while Start is running
+	runMu    sync.Mutex
+	stop     context.CancelFunc
+	stopped  chan struct{}
+	shutdown atomic.Bool
+
+	shutdownTimeout time.Duration
+
+	credentials CredentialProvider
+	secrets     *redactor
+	reconnect   ReconnectPolicy
+	// onDisconnect and onReconnect are run from a dedicated goroutine fed by linkEvents
+	linkEvents   *eventHub[linkEvent]
+	hooksMu      sync.Mutex
+	hooksRunning bool
+	onDisconnect func(err error)
+	onReconnect  func(attempts int, downtime time.Duration)
 }
 
-func NewServer(rconPassword, gameServer string) *Server {
-	return &Server{
+// NewServer returns a Server for the game server at gameServer, configured by opts.
+// The connection isn't opened until Start is called.
+//
+// rconPassword may be left empty when the password comes from WithCredentials instead
+func NewServer(rconPassword, gameServer string, opts ...Option) (*Server, error) {
+	cmdWriter := commands.NewDispatcher()
+	cmdWriter.SetTimeout(DefaultCommandTimeout)
+	cmdWriter.SetQueueLimit(DefaultQueueLimit, commands.QueueReject)
+
+	s := &Server{
 		Address:      gameServer,
-		cmdWriter:    commands.NewDispatcher(),
-		rconPassword: rconPassword,
+		cmdWriter:    cmdWriter,
+		events:       newEventHub[Event](),
+		stateChanges: newEventHub[StateChange](),
+		linkEvents:   newEventHub[linkEvent](),
+		logger:       discardLogger,
+		credentials:  StaticPassword(rconPassword),
+		secrets:      newRedactor(),
+		reconnect:    DefaultReconnectPolicy,
+		streamEvents: true,
+		keepAlive:    DefaultKeepAlive,
+		dialer:       &net.Dialer{},
+		network:      "tcp",
+		metrics:      noopMetrics{},
+
+		dialTimeout:   DefaultDialTimeout,
+		readTimeout:   DefaultReadTimeout,
+		writeTimeout:  DefaultWriteTimeout,
+		pollInterval:  DefaultStatePollInterval,
+		maxLineLength: DefaultMaxLineLength,
+
+		shutdownTimeout: DefaultShutdownTimeout,
 	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, fmt.Errorf("rcon: invalid option: %w", err)
+		}
+	}
+
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("rcon: invalid options: %w", err)
+	}
+
+	return s, nil
 }
 
 // Connect to the UDK game server and authenticate with the RCON password
 func (s *Server) Connect(ctx context.Context) (net.Conn, error) {
-	var d net.Dialer
+	return s.connect(ctx, s.commandRole())
+}
 
-	s.IsConnected = false
-	s.IsAuthenticated = false
+func (s *Server) connect(ctx context.Context, role linkRole) (net.Conn, error) {
+	s.setLinkState(role, StateDialing)
 
-	conn, err := d.DialContext(ctx, "tcp", s.Address)
+	// Asked on every connect so a rotated password is picked up
+	password, err := s.credentials.Password(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RCON at %s: %w", s.Address, err)
+		s.setLinkState(role, StateDisconnected)
+		return nil, fmt.Errorf("failed to get RCON password for %s: %w", s.Address, err)
 	}
+	s.secrets.addCredential(password)
 
-	if _, err := conn.Write([]byte(fmt.Sprintf("a%s\n", s.rconPassword))); err != nil {
-		return nil, fmt.Errorf("failed to authenticate to RCON at %s: %w", s.Address, err)
+	conn, err := s.dial(ctx)
+	if err != nil {
+		s.setLinkState(role, StateDisconnected)
+		return nil, err
+	}
+
+	authMsg := events.AppendFrame(nil, events.Authenticate, password)
+	s.record(capture.Outbound, "", string(authMsg))
+
+	if s.writeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
 	}
 
-	s.IsConnected = true
+	if err := events.NewEncoder(conn, s.maxLineLength).WriteFrame(authMsg); err != nil {
+		conn.Close()
+		s.setLinkState(role, StateDisconnected)
+		return nil, fmt.Errorf("failed to authenticate to RCON at %s: %w", s.ActiveAddress(), err)
+	}
+	s.metrics.LineWritten(len(authMsg))
+
+	s.setLinkState(role, StateAuthenticating)
 	return conn, nil
 }
 
+// serverInfo is what the game server told us about itself on the command connection
+type serverInfo struct {
+	game         games.Game
+	version      events.Version
+	connectionID string
+}
+
+// Game indicates which Totem Arts game this server is running. It's safe to call from any goroutine
+func (s *Server) Game() games.Game {
+	s.infoMu.RLock()
+	defer s.infoMu.RUnlock()
+
+	return s.info.game
+}
+
+// Version of the UDK Game Server. It's safe to call from any goroutine
+func (s *Server) Version() events.Version {
+	s.infoMu.RLock()
+	defer s.infoMu.RUnlock()
+
+	return s.info.version
+}
+
+// ConnectionID the game server assigned the command connection. It's safe to call from any goroutine
+func (s *Server) ConnectionID() string {
+	s.infoMu.RLock()
+	defer s.infoMu.RUnlock()
+
+	return s.info.connectionID
+}
+
 // Ready indicates this server has been connected to and authentication acknowledged
-func (s *Server) Ready() bool { return s.IsConnected && s.IsAuthenticated }
+func (s *Server) Ready() bool { return s.IsAuthenticated() }
 
 // Destroy should be called when we no longer need this server.
-// It frees up resources. Once destroyed, the server should be re-created to avoid issues
+// It gracefully shuts the server down, see Shutdown. Once destroyed, the server should be re-created to avoid issues
 func (s *Server) Destroy() {
-	s.IsConnected = false
-	s.IsAuthenticated = false
+	s.Shutdown(context.Background())
+}
+
+// WriteMsg queues msg to be sent to the game server, cb is called for every response line.
+// It fails with commands.ErrQueueFull if the queue is full, see WithQueueLimit
+func (s *Server) WriteMsg(msg commands.ICommand, cb commands.HandleCommandResp) error {
+	return s.cmdWriter.Enqueue(msg, cb)
+}
+
+// WriteMsgContext queues msg like WriteMsg. If tracing is enabled the command's span is a child of any span in ctx.
+// With commands.QueueBlock it waits for room in a full queue until ctx is done
+func (s *Server) WriteMsgContext(ctx context.Context, msg commands.ICommand, cb commands.HandleCommandResp) error {
+	return s.cmdWriter.EnqueueContext(ctx, msg, cb)
+}
 
+// WriteMsgWithDone queues msg like WriteMsgContext. done is called exactly once when the command finished,
+// with the error it failed with if any, such as an events.ServerError, commands.ErrCommandTimeout or ErrConnectionLost
+func (s *Server) WriteMsgWithDone(ctx context.Context, msg commands.ICommand, cb commands.HandleCommandResp, done commands.HandleCommandDone) error {
+	return s.cmdWriter.EnqueueWithDone(ctx, msg, cb, done)
 }
 
-func (s *Server) WriteMsg(msg commands.ICommand, cb commands.HandleCommandResp) {
-	s.cmdWriter.Enqueue(msg, cb)
+// Exec queues cmd and blocks until the game server finished executing it.
+//
+// The returned Response holds the header and every row the server sent back. If the server
+// answered with an error, it's returned as an events.ServerError. Cancelling ctx removes the
+// command from the queue, or gives up on it if it was already written so the next one can go out
+func (s *Server) Exec(ctx context.Context, cmd commands.ICommand) (commands.Response, error) {
+	pending, err := s.cmdWriter.SubmitContext(ctx, cmd, nil)
+	if err != nil {
+		return commands.Response{Command: cmd}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		pending.Cancel()
+		return commands.Response{Command: cmd}, ctx.Err()
+	case <-pending.Done():
+		resp := pending.Response()
+		return resp, resp.Err
+	}
 }
 
+// SendDevBot relays msg over the DevBot channel of the current connection
+func (s *Server) SendDevBot(msg events.DevBotMessage) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	sess := s.sess.Load()
+	if sess == nil || !s.IsAuthenticated() {
+		return ErrNotAuthenticated
+	}
+
+	return sess.write(msg.MarshalRCON())
+}
+
+// record writes a line to the capture file, if one is configured
+func (s *Server) record(dir capture.Direction, connectionID, line string) {
+	if s.capture == nil {
+		return
+	}
+
+	err := s.capture.Write(capture.Record{
+		Time:         time.Now(),
+		Direction:    dir,
+		ConnectionID: connectionID,
+		Line:         s.secrets.redact(line),
+	})
+	if err != nil {
+		s.logger.Warn("failed to write RCON capture", "error", err)
+	}
+}
+
+// OnDisconnect registers a callback invoked every time an established RCON link is lost.
+// With WithSplitConnections it's called for either connection.
+//
+// Like OnReconnect, it's called from a dedicated goroutine in the order things happened so a slow
+// callback never holds up the connection. It may be registered at any time
+func (s *Server) OnDisconnect(fn func(err error)) {
+	s.setHook(func() { s.onDisconnect = fn })
+}
+
+// OnReconnect registers a callback invoked every time the game server accepted our credentials
+// again after the RCON link was lost, for either connection with WithSplitConnections.
+// attempts is the number of dial attempts it took and downtime how long we were disconnected
+func (s *Server) OnReconnect(fn func(attempts int, downtime time.Duration)) {
+	s.setHook(func() { s.onReconnect = fn })
+}
+
+// linkEvent is a lost or regained RCON link, delivered to OnDisconnect or OnReconnect
+type linkEvent struct {
+	lost     bool
+	err      error
+	attempts int
+	downtime time.Duration
+}
+
+// setHook changes a callback with set, starting the goroutine running them the first time
+func (s *Server) setHook(set func()) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+
+	set()
+	if s.hooksRunning {
+		return
+	}
+	s.hooksRunning = true
+
+	sub, _ := s.linkEvents.subscribe(EventQueueSize, nil)
+	sub.callback(s.runHook)
+}
+
+func (s *Server) runHook(ev linkEvent) {
+	s.hooksMu.Lock()
+	onDisconnect, onReconnect := s.onDisconnect, s.onReconnect
+	s.hooksMu.Unlock()
+
+	switch {
+	case ev.lost && onDisconnect != nil:
+		onDisconnect(ev.err)
+	case !ev.lost && onReconnect != nil:
+		onReconnect(ev.attempts, ev.downtime)
+	}
+}
+
+// Start connects to the game server and processes RCON messages until ctx is cancelled
+// or Shutdown is called.
+//
+// Lost connections are re-established according to the ReconnectPolicy. Start only returns
+// an error once the policy is exhausted or the server sent something we cannot recover from.
+// It fails with ErrAlreadyRunning if called again before the previous call returned
 func (s *Server) Start(ctx context.Context) error {
+	ctx, cancel, stopped, err := s.running(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.stopRunning(stopped)
+
 	state := NewGameState(s)
+	state.SetLogger(s.logger)
+	state.SetPollInterval(s.pollInterval)
+	s.GameState = state
 
+	stateDone := make(chan struct{})
 	go func() {
+		defer close(stateDone)
 		state.Start(ctx)
 	}()
+	defer func() {
+		cancel()
+		<-stateDone
+	}()
+
+	if !s.split {
+		return s.run(ctx, linkShared)
+	}
+
+	// Either connection failing for good takes the other one down with it
+	linkCtx, cancelLinks := context.WithCancel(ctx)
+	defer cancelLinks()
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.run(linkCtx, linkStream) }()
+	go func() { errs <- s.run(linkCtx, linkCommands) }()
+
+	err = <-errs
+	cancelLinks()
+
+	if other := <-errs; err == nil {
+		err = other
+	}
+
+	return err
+}
+
+// run keeps the connection used for role established until ctx is cancelled
+// or the ReconnectPolicy gives up
+func (s *Server) run(ctx context.Context, role linkRole) error {
+	logger := s.logger
+	if role != linkShared {
+		logger = logger.With("connection", role.String())
+	}
+
+	var (
+		attempts    int
+		failovers   int
+		lostAt      time.Time
+		everStarted bool
+	)
 
 MainLoop:
 	for {
-		select {
-		case <-ctx.Done():
-			break MainLoop
-		default:
-			var rdr bufio.Reader
-
-			{
-				conn, err := s.Connect(ctx)
-				if err != nil {
-					return err
+		conn, err := s.connect(ctx, role)
+		if err == nil {
+			// Only a connection the game server let us in on counts as re-established,
+			// until then we're still down and retrying
+			var authenticated bool
+			err = s.serve(ctx, conn, role, func() {
+				authenticated = true
+
+				if everStarted {
+					s.metrics.Reconnected()
+					s.linkEvents.publish(linkEvent{attempts: attempts, downtime: time.Since(lostAt)})
 				}
-				defer conn.Close()
-
-				rdr.Reset(conn)
-
-			ReadLoop:
-				for {
-					select {
-					case <-ctx.Done():
-						break MainLoop
-					default:
-					}
-
-					// Design Note: Writes to RCON are so in-frequent here
-					// we're going to use the same loop for both reading and writing
-					if cmd := s.cmdWriter.Next(); cmd != nil {
-						msg := cmd.MarshalRCON()
-						log.Printf("[ !! ] Writing message to rcon: %s\n", msg)
-
-						conn.SetWriteDeadline(time.Now().Add(time.Second * 2))
-						if _, err := conn.Write(msg); err != nil {
-							log.Printf("Failed to write to RCON msg at %s: %s", s.Address, err)
-						}
-					}
-
-					conn.SetReadDeadline(time.Now().Add(time.Second * 1))
-					msg, err := rdr.ReadString('\n')
-					if err != nil {
-						if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-							continue ReadLoop // Keep going
-						}
-
-						log.Printf("Failed to read RCON msg: %s", err)
-						break ReadLoop
-					}
-
-					if len(msg) < 2 {
-						log.Printf("RCON msg length of %d too small", len(msg))
-						break ReadLoop
-					}
-
-					_ = msg[1] // Bounds check
-
-					msgNoType := msg[1:]
-					switch events.ServerType(msg[0]) {
-					case events.RCONGameVersion:
-						var ver events.Version
-
-						if err := ver.Parse(msgNoType); err != nil {
-							// If we cant parse the version, we're gonna bomb out
-							// because we might run into unexpected behavior
-							return err
-						}
-
-						switch {
-						case (ver.GameVersion > 12000 && ver.GameVersion < 13000):
-							s.Game = games.GameRenegadeX
-						default:
-							s.Game = games.GameUnknown
-						}
-
-						log.Printf("[ !! ] %s", ver)
-					case events.AuthenticationSuccess:
-						s.ConnectionID = msgNoType
-						s.IsAuthenticated = true
-
-						log.Printf("[ ++ ] Got AuthSuccess, starting event stream!\n")
-						// Authenticated and ready to accept streaming!
-						if _, err := conn.Write([]byte(fmt.Sprintf("s\n"))); err != nil {
-							log.Fatalf("Failed to write RCON auth: %s\n", err)
-						}
-					case events.Error:
-						var err events.ServerError
-						err.Parse(msgNoType)
-
-						// If we're not authenticated and we get an error, bomb out
-						if !s.IsAuthenticated {
-							return err
-						}
-
-						// Otherwise, just log the error
-						log.Printf("[ XX ] RCON error: %s\n", err)
-						s.cmdWriter.CommandDone()
-					case events.CommandResponse:
-						// log.Printf("[ !! ] Command Response: %s", msgNoType)
-						s.cmdWriter.OnMsg(msgNoType)
-					case events.CommandExecutionFinished:
-						log.Printf("[ !! ] Command Done\n")
-						s.cmdWriter.CommandDone()
-					case events.GameLog:
-					case events.ServerDevBot:
-						fmt.Println(msg)
-					}
+
+				everStarted = true
+				attempts = 0
+			})
+			conn.Close()
+
+			if ctx.Err() != nil || errors.Is(err, capture.ErrEndOfReplay) {
+				break MainLoop
+			}
+
+			s.setLinkState(role, StateDisconnected)
+
+			switch {
+			case authenticated:
+				lostAt = time.Now()
+				s.linkEvents.publish(linkEvent{lost: true, err: err})
+			case lostAt.IsZero():
+				lostAt = time.Now()
+			}
+
+			// Another address of the same game server may still let us in
+			var aerr authError
+			if errors.As(err, &aerr) {
+				if failovers++; failovers < s.addressCount() {
+					next := s.failover(aerr.addr)
+					logger.Warn("RCON authentication failed, trying the next address", "error", err, "next_address", next)
+					continue MainLoop
 				}
 			}
+			failovers = 0
+		} else if lostAt.IsZero() {
+			lostAt = time.Now()
+		}
+
+		if ctx.Err() != nil {
+			break MainLoop
+		}
+
+		if !s.retryable(err) {
+			var perr permanentError
+			if errors.As(err, &perr) {
+				return perr.err
+			}
+
+			return err
+		}
+
+		attempts++
+		if s.reconnect.exhausted(attempts, lostAt) {
+			return err
+		}
+
+		delay := s.reconnect.Delay(attempts)
+		logger.Warn("RCON link down, reconnecting", "error", err, "attempt", attempts, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			break MainLoop
+		case <-timer.C:
 		}
 	}
 
-	s.IsConnected = false
-	log.Printf("[ !! ] Goodbye!")
+	s.setLinkState(role, StateDisconnected)
+	logger.Info("RCON link closed")
 	return nil
 }
@@ -0,0 +1,682 @@
+package rcon_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	rcon "github.com/tankbusta/renx-rcon"
+	"github.com/tankbusta/renx-rcon/capture"
+	"github.com/tankbusta/renx-rcon/commands"
+	"github.com/tankbusta/renx-rcon/events"
+	"github.com/tankbusta/renx-rcon/rcontest"
+	"golang.org/x/time/rate"
+)
+
+const testPassword = "hunter2"
+
+func newServer(t *testing.T, password, addr string, opts ...rcon.Option) *rcon.Server {
+	t.Helper()
+
+	svr, err := rcon.NewServer(password, addr, opts...)
+	require.NoError(t, err)
+
+	return svr
+}
+
+// startServer runs svr until the test finishes, the returned channel receives the error Start returned
+func startServer(t *testing.T, svr *rcon.Server) <-chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		errs <- svr.Start(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return errs
+}
+
+func waitReady(t *testing.T, svr *rcon.Server) {
+	t.Helper()
+	require.Eventually(t, svr.Ready, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestServerExec(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	// The game server separates header columns with spaces
+	header := "PORT SERVERNAME GETPACKAGENAME"
+	ts.Handle("ServerInfo", rcontest.Response{
+		Header: header,
+		Rows:   []string{strings.Join([]string{"7777", "Renegade X Server", "CNC-Field"}, string(events.Delimiter))},
+	})
+
+	svr := newServer(t, testPassword, ts.Addr)
+	startServer(t, svr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cmd := commands.NewServerInfoCommand()
+	resp, err := svr.Exec(ctx, cmd)
+	require.NoError(t, err)
+	require.Equal(t, header, resp.Header)
+	require.Len(t, resp.Rows, 1)
+
+	var info events.ServerInfo
+	require.NoError(t, cmd.UnmarshalRCON(resp.Rows[0], &info))
+	require.Equal(t, "CNC-Field", info.Map)
+	require.Equal(t, "1", svr.ConnectionID())
+	require.Equal(t, 5887, svr.Version().GameVersion)
+
+	_, err = svr.Exec(ctx, commands.NewListBotsCommand())
+	require.ErrorIs(t, err, events.ErrUnknownCommand)
+
+	// The `c` following the error must not finish the next command
+	resp, err = svr.Exec(ctx, cmd)
+	require.NoError(t, err)
+	require.Equal(t, header, resp.Header)
+	require.Len(t, resp.Rows, 1)
+}
+
+func TestServerEvents(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	svr := newServer(t, testPassword, ts.Addr)
+
+	chat, unsubscribe := svr.Events(10, rcon.FilterLog(events.LogTypeChat, events.ActivitySay))
+	defer unsubscribe()
+
+	startServer(t, svr)
+	require.Eventually(t, func() bool { return ts.Subscribed() == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	ts.EmitLog("GAME\x02Spawn;\x02player\x02Nod")
+	ts.EmitLog("CHAT\x02Say;\x02player\x02gg")
+
+	select {
+	case ev := <-chat:
+		require.NoError(t, ev.Err)
+		require.Equal(t, events.ActivitySay, ev.Log.Activity)
+		require.Equal(t, []string{"player", "gg"}, ev.Log.Parts)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for chat event")
+	}
+}
+
+func TestServerAlreadyRunning(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	svr := newServer(t, testPassword, ts.Addr)
+	errs := startServer(t, svr)
+	waitReady(t, svr)
+
+	require.ErrorIs(t, svr.Start(context.Background()), rcon.ErrAlreadyRunning)
+	require.Equal(t, 1, ts.Connections())
+
+	// The first Start is still the one Shutdown stops
+	require.NoError(t, svr.Shutdown(context.Background()))
+	require.NoError(t, <-errs)
+}
+
+func TestServerCommandOnly(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	svr := newServer(t, testPassword, ts.Addr, rcon.WithStreamEvents(false))
+
+	startServer(t, svr)
+	waitReady(t, svr)
+	require.Equal(t, rcon.StateAuthenticated, svr.State())
+	require.Zero(t, ts.Subscribed())
+
+	require.NoError(t, svr.SubscribeStream())
+	require.Eventually(t, func() bool { return ts.Subscribed() == 1 }, 2*time.Second, 5*time.Millisecond)
+	require.Equal(t, rcon.StateStreaming, svr.State())
+}
+
+func TestServerBadPassword(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	svr := newServer(t, "wrong", ts.Addr)
+
+	select {
+	case err := <-startServer(t, svr):
+		require.ErrorIs(t, err, events.ErrBadPassword)
+		require.False(t, rcon.Retryable(err))
+	case <-time.After(2 * time.Second):
+		t.Fatal("bad password should not be retried")
+	}
+}
+
+func TestServerReconnect(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	svr := newServer(t, testPassword, ts.Addr, rcon.WithReconnectPolicy(rcon.ReconnectPolicy{InitialInterval: 10 * time.Millisecond}))
+	startServer(t, svr)
+	waitReady(t, svr)
+
+	// Callbacks may be registered while running, a slow one doesn't hold up the connection
+	disconnected := make(chan error, 10)
+	svr.OnDisconnect(func(err error) {
+		time.Sleep(100 * time.Millisecond)
+		disconnected <- err
+	})
+
+	reconnected := make(chan int, 1)
+	svr.OnReconnect(func(attempts int, downtime time.Duration) { reconnected <- attempts })
+
+	// A connection the game server refuses doesn't count as regained
+	ts.RejectAuth("Too many connections")
+	ts.Disconnect()
+	require.Eventually(t, func() bool { return len(ts.Received()) > 2 }, 2*time.Second, 5*time.Millisecond)
+
+	select {
+	case <-reconnected:
+		t.Fatal("reconnect reported before authenticating")
+	default:
+	}
+
+	ts.RejectAuth("")
+
+	select {
+	case attempts := <-reconnected:
+		require.Greater(t, attempts, 1)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for reconnect")
+	}
+
+	waitReady(t, svr)
+	require.Error(t, <-disconnected)
+}
+
+func TestServerCredentials(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	var asked atomic.Int32
+	creds := rcon.CredentialFunc(func(context.Context) (string, error) {
+		asked.Add(1)
+		return testPassword, nil
+	})
+
+	capturePath := filepath.Join(t.TempDir(), "session.cap")
+	cw, err := capture.NewWriter(capturePath, capture.Options{})
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	svr := newServer(t, "", ts.Addr,
+		rcon.WithCredentials(creds),
+		rcon.WithSensitiveCommands("ServerInfo"),
+		rcon.WithCapture(cw),
+		rcon.WithLogger(logger),
+		rcon.WithReconnectPolicy(rcon.ReconnectPolicy{InitialInterval: 10 * time.Millisecond}),
+	)
+	startServer(t, svr)
+	waitReady(t, svr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err = svr.Exec(ctx, commands.NewServerInfoCommand())
+	require.ErrorIs(t, err, events.ErrUnknownCommand)
+
+	// The password is asked for again on reconnect
+	ts.Disconnect()
+	require.Eventually(t, func() bool { return asked.Load() == 2 }, 2*time.Second, 5*time.Millisecond)
+	waitReady(t, svr)
+	require.NoError(t, svr.Shutdown(ctx))
+	require.NoError(t, cw.Close())
+
+	captured, err := os.ReadFile(capturePath)
+	require.NoError(t, err)
+
+	args := strings.TrimSuffix(string(commands.NewServerInfoCommand().MarshalRCON()), "\n")
+	args = args[strings.Index(args, " ")+1:]
+
+	for _, out := range []string{string(captured), logs.String()} {
+		require.NotContains(t, out, testPassword)
+		require.NotContains(t, out, args)
+	}
+	require.Contains(t, string(captured), "cServerInfo "+capture.Redacted)
+}
+
+func TestServerRotatedPassword(t *testing.T) {
+	ts := rcontest.NewServer("rotated")
+	defer ts.Close()
+
+	// The game server already uses the new password, our secret only catches up later
+	var asked atomic.Int32
+	creds := rcon.CredentialFunc(func(context.Context) (string, error) {
+		if asked.Add(1) < 3 {
+			return testPassword, nil
+		}
+
+		return "rotated", nil
+	})
+
+	svr := newServer(t, "", ts.Addr,
+		rcon.WithCredentials(rcon.RotatingCredentials(creds)),
+		rcon.WithReconnectPolicy(rcon.ReconnectPolicy{InitialInterval: 10 * time.Millisecond}),
+	)
+	errs := startServer(t, svr)
+	waitReady(t, svr)
+
+	select {
+	case err := <-errs:
+		t.Fatalf("Start gave up: %s", err)
+	default:
+	}
+	require.EqualValues(t, 3, asked.Load())
+
+	// The environment can't change underneath us, so a wrong password is given up on
+	t.Setenv("TEST_RCON_PASSWORD", "wrong")
+	svr = newServer(t, "", ts.Addr,
+		rcon.WithCredentials(rcon.EnvPassword("TEST_RCON_PASSWORD")),
+		rcon.WithReconnectPolicy(rcon.ReconnectPolicy{InitialInterval: 10 * time.Millisecond}),
+	)
+
+	select {
+	case err := <-startServer(t, svr):
+		require.ErrorIs(t, err, events.ErrBadPassword)
+	case <-time.After(2 * time.Second):
+		t.Fatal("a rejected password from the environment must not be retried")
+	}
+}
+
+func TestServerSplitConnections(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	svr := newServer(t, testPassword, ts.Addr,
+		rcon.WithSplitConnections(true),
+		rcon.WithReconnectPolicy(rcon.ReconnectPolicy{InitialInterval: 10 * time.Millisecond}),
+	)
+
+	logs, unsubscribe := svr.Events(10, rcon.FilterLog(events.LogTypeChat, events.ActivitySay))
+	defer unsubscribe()
+
+	changes, unsubscribeChanges := svr.StateChanges(64)
+	defer unsubscribeChanges()
+
+	streaming := func() bool {
+		return svr.Ready() && svr.StreamState() == rcon.StateStreaming && ts.Connections() == 2
+	}
+
+	startServer(t, svr)
+	require.Eventually(t, streaming, 2*time.Second, 5*time.Millisecond)
+	require.Equal(t, rcon.StateAuthenticated, svr.State(), "the command connection isn't subscribed")
+	require.Equal(t, 1, ts.Subscribed())
+
+	// Transitions of both connections are published
+	reached := make(map[string]rcon.ConnState)
+	for reached[rcon.ConnectionCommands] != rcon.StateAuthenticated || reached[rcon.ConnectionStream] != rcon.StateStreaming {
+		select {
+		case change := <-changes:
+			reached[change.Connection] = change.To
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for state changes, got %v", reached)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := svr.Exec(ctx, commands.NewKeepAliveCommand())
+	require.NoError(t, err)
+
+	ts.EmitLog("CHAT\x02Say;\x02player\x02gg")
+	select {
+	case ev := <-logs:
+		require.Equal(t, []string{"player", "gg"}, ev.Log.Parts)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for chat event")
+	}
+
+	// Both connections come back on their own
+	ts.Disconnect()
+	require.Eventually(t, func() bool { return !svr.Ready() || ts.Connections() < 2 }, 2*time.Second, time.Millisecond)
+	require.Eventually(t, streaming, 2*time.Second, 5*time.Millisecond)
+
+	_, err = svr.Exec(ctx, commands.NewKeepAliveCommand())
+	require.NoError(t, err)
+}
+
+func TestServerSplitConnectionsQueued(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	svr := newServer(t, testPassword, ts.Addr, rcon.WithSplitConnections(true))
+
+	// Queued before either connection is up, whichever authenticates first
+	const queued = 20
+	results := make(chan error, queued)
+	for i := 0; i < queued; i++ {
+		require.NoError(t, svr.WriteMsgWithDone(context.Background(), commands.NewKeepAliveCommand(), nil,
+			func(cmd commands.ICommand, err error) { results <- err }))
+	}
+
+	startServer(t, svr)
+
+	for i := 0; i < queued; i++ {
+		select {
+		case err := <-results:
+			require.NoError(t, err, "commands must only go out on the command connection")
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for command %d", i)
+		}
+	}
+}
+
+func TestServerFailover(t *testing.T) {
+	// Nothing listens on the first address anymore
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := ln.Addr().String()
+	ln.Close()
+
+	// The second rejects our password
+	rejecting := rcontest.NewServer("not-" + testPassword)
+	defer rejecting.Close()
+
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	svr := newServer(t, testPassword, unreachable, rcon.WithAddresses(rejecting.Addr, ts.Addr))
+	require.Empty(t, svr.ActiveAddress())
+
+	startServer(t, svr)
+	waitReady(t, svr)
+	require.Equal(t, ts.Addr, svr.ActiveAddress())
+	require.Equal(t, []string{"a" + testPassword}, rejecting.Received(), "the rejecting address was tried")
+}
+
+func TestServerCommandTimeout(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	ts.Handle("BotVarList", rcontest.Response{Silent: true})
+
+	svr := newServer(t, testPassword, ts.Addr, rcon.WithCommandTimeoutFor("BotVarList", 50*time.Millisecond))
+	startServer(t, svr)
+	waitReady(t, svr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := svr.Exec(ctx, commands.NewListBotsCommand())
+	require.ErrorIs(t, err, commands.ErrCommandTimeout)
+
+	_, err = svr.Exec(ctx, commands.NewKeepAliveCommand())
+	require.NoError(t, err, "the queue keeps moving after a timeout")
+}
+
+func TestServerRateLimit(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	// A single command goes out, the next one waits practically forever
+	svr := newServer(t, testPassword, ts.Addr, rcon.WithRateLimit(rate.Every(time.Hour), 1), rcon.WithKeepAlive(0))
+	startServer(t, svr)
+	waitReady(t, svr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := svr.Exec(ctx, commands.NewKeepAliveCommand())
+	require.NoError(t, err)
+	require.NoError(t, svr.WriteMsg(commands.NewServerInfoCommand(), nil))
+
+	// Raw lines aren't held up by commands waiting for the rate limiter
+	require.NoError(t, svr.SendDevBot(events.NewDevBotMessage("hello")))
+	require.Eventually(t, func() bool {
+		received := ts.Received()
+		return received[len(received)-1] == "dhello"
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, []string{"ping"}, ts.Commands())
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	pings := func(ts *rcontest.Server) int {
+		var n int
+		for _, name := range ts.Commands() {
+			if name == "ping" {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	t.Run("Idle", func(t *testing.T) {
+		ts := rcontest.NewServer(testPassword)
+		defer ts.Close()
+
+		svr := newServer(t, testPassword, ts.Addr, rcon.WithKeepAlive(40*time.Millisecond))
+		startServer(t, svr)
+		waitReady(t, svr)
+
+		require.Eventually(t, func() bool { return pings(ts) >= 2 }, 2*time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("Busy", func(t *testing.T) {
+		ts := rcontest.NewServer(testPassword)
+		defer ts.Close()
+		ts.Handle("ServerInfo", rcontest.Response{Header: "PORT", Rows: []string{"7777"}})
+
+		svr := newServer(t, testPassword, ts.Addr, rcon.WithKeepAlive(100*time.Millisecond))
+		startServer(t, svr)
+		waitReady(t, svr)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		for deadline := time.Now().Add(300 * time.Millisecond); time.Now().Before(deadline); {
+			_, err := svr.Exec(ctx, commands.NewServerInfoCommand())
+			require.NoError(t, err)
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		require.Zero(t, pings(ts), "a connection in use needs no keepalive")
+	})
+
+	t.Run("Outstanding", func(t *testing.T) {
+		ts := rcontest.NewServer(testPassword)
+		defer ts.Close()
+		ts.Handle("ping", rcontest.Response{Silent: true})
+
+		svr := newServer(t, testPassword, ts.Addr,
+			rcon.WithKeepAlive(20*time.Millisecond),
+			rcon.WithCommandTimeoutFor("ping", 200*time.Millisecond),
+		)
+		startServer(t, svr)
+		waitReady(t, svr)
+
+		require.Eventually(t, func() bool { return pings(ts) == 1 }, 2*time.Second, 5*time.Millisecond)
+
+		// No second keepalive while the first one is unanswered, even though we're idle
+		time.Sleep(100 * time.Millisecond)
+		require.Equal(t, 1, pings(ts))
+
+		// Once it timed out the next one goes out
+		require.Eventually(t, func() bool { return pings(ts) == 2 }, 2*time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestServerPipeDialer(t *testing.T) {
+	ts := rcontest.NewUnstartedServer(testPassword)
+
+	svr := newServer(t, testPassword, "in-memory", rcon.WithDialer(rcon.PipeDialer(ts.ServeConn)))
+
+	startServer(t, svr)
+	waitReady(t, svr)
+
+	require.NoError(t, svr.SendDevBot(events.NewDevBotMessage("hello")))
+	require.Eventually(t, func() bool {
+		received := ts.Received()
+		return received[len(received)-1] == "dhello"
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestServerShutdown(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	release := make(chan struct{})
+	ts.HandleFunc("ServerInfo", func(string) rcontest.Response {
+		<-release
+		return rcontest.Response{Header: "PORT", Rows: []string{"7777"}}
+	})
+
+	svr := newServer(t, testPassword, ts.Addr)
+	errs := startServer(t, svr)
+	require.Eventually(t, func() bool { return ts.Subscribed() == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	execErr := make(chan error, 1)
+	go func() {
+		_, err := svr.Exec(context.Background(), commands.NewServerInfoCommand())
+		execErr <- err
+	}()
+	require.Eventually(t, func() bool { return len(ts.Commands()) == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- svr.Shutdown(context.Background()) }()
+
+	// The command in flight is allowed to finish
+	close(release)
+	require.NoError(t, <-execErr)
+	require.NoError(t, <-shutdownErr)
+	require.NoError(t, <-errs)
+
+	require.Eventually(t, func() bool {
+		received := ts.Received()
+		return received[len(received)-1] == "u"
+	}, 2*time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := svr.Exec(ctx, commands.NewListBotsCommand())
+	require.ErrorIs(t, err, rcon.ErrShutdown)
+	require.ErrorIs(t, svr.Start(ctx), rcon.ErrShutdown)
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	ts := rcontest.NewServer(testPassword)
+	defer ts.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	ts.HandleFunc("ServerInfo", func(string) rcontest.Response {
+		<-release
+		return rcontest.Response{}
+	})
+
+	svr := newServer(t, testPassword, ts.Addr, rcon.WithShutdownTimeout(100*time.Millisecond))
+	startServer(t, svr)
+	waitReady(t, svr)
+
+	execErr := make(chan error, 1)
+	go func() {
+		_, err := svr.Exec(context.Background(), commands.NewServerInfoCommand())
+		execErr <- err
+	}()
+	require.Eventually(t, func() bool { return len(ts.Commands()) == 1 }, 2*time.Second, 5*time.Millisecond)
+
+	require.ErrorIs(t, svr.Shutdown(context.Background()), context.DeadlineExceeded)
+	require.ErrorIs(t, <-execErr, rcon.ErrShutdown)
+}
+
+func TestNewServerValidation(t *testing.T) {
+	_, err := rcon.NewServer(testPassword, "")
+	require.Error(t, err)
+
+	_, err = rcon.NewServer("", "127.0.0.1:7777")
+	require.Error(t, err, "an empty password would be retried forever")
+
+	_, err = rcon.NewServer(testPassword, "127.0.0.1:7777", rcon.WithWriteTimeout(-time.Second))
+	require.Error(t, err)
+
+	_, err = rcon.NewServer(testPassword, "127.0.0.1:7777",
+		rcon.WithKeepAlive(time.Minute),
+		rcon.WithReadTimeout(30*time.Second),
+	)
+	require.Error(t, err, "read timeout shorter than the keepalive would drop healthy connections")
+
+	_, err = rcon.NewServer(testPassword, "127.0.0.1:7777", rcon.WithReconnectPolicy(rcon.ReconnectPolicy{}))
+	require.Error(t, err)
+
+	_, err = rcon.NewServer(testPassword, "127.0.0.1:7777", rcon.WithReconnectPolicy(rcon.NoReconnect))
+	require.NoError(t, err)
+}
+
+func TestReplayServer(t *testing.T) {
+	now := time.Now()
+	botVarList := string(commands.NewListBotsCommand().MarshalRCON())
+
+	var capfile strings.Builder
+	for _, rec := range []capture.Record{
+		{Time: now, Direction: capture.Inbound, Line: "v" + rcontest.DefaultVersion + "\n"},
+		{Time: now, Direction: capture.Outbound, Line: "a" + capture.Redacted + "\n"},
+		{Time: now, Direction: capture.Inbound, Line: "a1\n"},
+		{Time: now, Direction: capture.Outbound, Line: "s\n"},
+		{Time: now, Direction: capture.Inbound, Line: "lCHAT\x02Say;\x02player\x02gg\n"},
+		{Time: now, Direction: capture.Outbound, Line: botVarList},
+		{Time: now, Direction: capture.Inbound, Line: "rID\x02NAME\x02TEAM\x02SCORE\x02CREDITS\x02CHARACTER\n"},
+		{Time: now, Direction: capture.Inbound, Line: "r1\x02Havoc\x02GDI\x0250\x02300\x02Rx_Havoc\n"},
+		{Time: now, Direction: capture.Inbound, Line: "c\n"},
+	} {
+		capfile.WriteString(rec.String() + "\n")
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	svr, err := rcon.NewReplayServer(strings.NewReader(capfile.String()), capture.ReplayOptions{}, rcon.WithLogger(logger))
+	require.NoError(t, err)
+
+	chat, unsubscribe := svr.Events(10, rcon.FilterLog(events.LogTypeChat, events.ActivitySay))
+	defer unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	require.Nil(t, svr.GameState)
+	require.NoError(t, svr.Start(ctx), "Start returns once the capture was played back")
+	require.NoError(t, ctx.Err())
+	require.NotNil(t, svr.GameState, "the manager Start created is reachable")
+
+	select {
+	case ev := <-chat:
+		require.Equal(t, []string{"player", "gg"}, ev.Log.Parts)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for chat event")
+	}
+
+	// The recorded BotVarList response reached the GameStateManager instead of being dropped
+	require.Contains(t, logs.String(), "received bot state update")
+	require.Contains(t, logs.String(), "player=Havoc")
+}
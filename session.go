@@ -0,0 +1,467 @@
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tankbusta/renx-rcon/capture"
+	"github.com/tankbusta/renx-rcon/commands"
+	"github.com/tankbusta/renx-rcon/events"
+	"github.com/tankbusta/renx-rcon/games"
+)
+
+var (
+	// ErrConnectionLost is returned for commands that were in flight when the RCON link dropped
+	ErrConnectionLost = errors.New("rcon: connection lost before the command finished")
+
+	// ErrNotAuthenticated is returned when sending something that requires an authenticated connection
+	ErrNotAuthenticated = errors.New("rcon: not authenticated")
+
+	errSessionClosed = errors.New("rcon: session closed")
+)
+
+// session is a single established RCON connection.
+//
+// Reading and writing happen on dedicated goroutines so a queued command is written
+// as soon as the Dispatcher releases it, regardless of what the reader is doing.
+type session struct {
+	conn net.Conn
+	enc  *events.Encoder
+	role linkRole
+
+	// addr is the address of the game server we're connected to
+	addr string
+
+	// out feeds raw protocol lines (subscribe, unsubscribe, ...) to the writer
+	out  chan []byte
+	done chan struct{}
+
+	// authenticated is closed once the game server accepted our credentials
+	authenticated chan struct{}
+
+	// onAuth is called from the reader once authenticated is closed
+	onAuth func()
+
+	// keepAlive is the last keepalive command, only touched by the writer
+	keepAlive *commands.Pending
+
+	// connectionID is the ID the game server assigned this connection
+	connectionID atomic.Value
+
+	// lastWrite is when we last wrote to the connection, in unix nanoseconds
+	lastWrite atomic.Int64
+}
+
+func newSession(conn net.Conn, role linkRole, maxLineLength int) *session {
+	return &session{
+		conn: conn,
+		enc:  events.NewEncoder(conn, maxLineLength),
+		role: role,
+		out:  make(chan []byte, WriterSizeQueue),
+		done: make(chan struct{}),
+
+		authenticated: make(chan struct{}),
+	}
+}
+
+func (s *session) id() string {
+	id, _ := s.connectionID.Load().(string)
+	return id
+}
+
+// write queues a raw message for the writer goroutine
+func (s *session) write(msg []byte) error {
+	select {
+	case s.out <- msg:
+		return nil
+	case <-s.done:
+		return errSessionClosed
+	}
+}
+
+// serve processes messages on an established connection until it fails or ctx is cancelled.
+// onAuth is called once the game server accepted our credentials, before serve returns
+func (s *Server) serve(ctx context.Context, conn net.Conn, role linkRole, onAuth func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sess := newSession(conn, role, s.maxLineLength)
+	sess.addr = s.ActiveAddress()
+	sess.onAuth = onAuth
+	errs := make(chan error, 2)
+
+	current := s.linkSession(role)
+	current.Store(sess)
+	defer current.Store(nil)
+
+	go func() { errs <- s.writeLoop(ctx, sess) }()
+	go func() { errs <- s.readLoop(ctx, sess) }()
+
+	// Whichever side stops first tears down the other
+	err := <-errs
+	if ctx.Err() != nil {
+		s.setLinkState(role, StateClosing)
+	}
+
+	cancel()
+	close(sess.done)
+	conn.Close()
+	<-errs
+
+	// Whatever was in flight on this connection will never be answered
+	if role.commands() {
+		s.cmdWriter.CommandFailed(ErrConnectionLost)
+	}
+
+	return err
+}
+
+func (s *Server) writeLoop(ctx context.Context, sess *session) error {
+	// Commands are held back until the game server has accepted our credentials
+	var cmdReady <-chan struct{}
+	authenticated := sess.authenticated
+
+	// Check twice per interval so we never go more than 1.5 intervals without writing
+	var keepAlive <-chan time.Time
+	if s.keepAlive > 0 {
+		ticker := time.NewTicker(s.keepAlive / 2)
+		defer ticker.Stop()
+
+		keepAlive = ticker.C
+	}
+
+	// While a command waits for the rate limiter we stop watching cmdReady and wait for throttled
+	// instead, raw lines keep flowing in the meantime
+	var (
+		throttle  *time.Timer
+		throttled <-chan time.Time
+	)
+	defer func() {
+		if throttle != nil {
+			throttle.Stop()
+		}
+	}()
+
+	// Only the connection the Dispatcher is bound to may take commands off the queue, nothing reads
+	// the answers on the stream connection
+	nextCmd := func() error {
+		if !sess.role.commands() || !s.cmdWriter.HasNext() {
+			return nil
+		}
+
+		if s.limiter != nil {
+			if delay := s.limiter.Reserve().Delay(); delay > 0 {
+				throttle = time.NewTimer(delay)
+				throttled = throttle.C
+				cmdReady = nil
+				return nil
+			}
+		}
+
+		return s.writeNextCmd(sess)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Lines such as the unsubscribe queued by Shutdown go out before the connection closes
+			s.flushOut(sess)
+			return ctx.Err()
+		case msg := <-sess.out:
+			if err := s.writeConn(sess, msg); err != nil {
+				return err
+			}
+		case <-authenticated:
+			authenticated = nil
+			if !sess.role.commands() {
+				continue
+			}
+
+			cmdReady = s.cmdWriter.Ready()
+			if err := nextCmd(); err != nil {
+				return err
+			}
+		case <-cmdReady:
+			if err := nextCmd(); err != nil {
+				return err
+			}
+		case <-throttled:
+			throttled = nil
+			cmdReady = s.cmdWriter.Ready()
+
+			// The token was already taken when we started waiting
+			if err := s.writeNextCmd(sess); err != nil {
+				return err
+			}
+		case <-keepAlive:
+			if authenticated == nil {
+				if err := s.sendKeepAlive(sess); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// flushOut writes every raw line already queued on the session
+func (s *Server) flushOut(sess *session) {
+	for {
+		select {
+		case msg := <-sess.out:
+			if err := s.writeConn(sess, msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// writeNextCmd writes the next queued command, if any. Rate limiting is up to the caller
+func (s *Server) writeNextCmd(sess *session) error {
+	cmd := s.cmdWriter.Next()
+	if cmd == nil {
+		return nil
+	}
+
+	msg := cmd.MarshalRCON()
+	if s.secrets.isSensitive(cmd.Command()) {
+		line := strings.TrimSuffix(string(msg[1:]), "\n")
+		if _, args, ok := strings.Cut(line, " "); ok {
+			s.secrets.addSecret(args)
+		}
+	}
+	s.logger.Debug("writing RCON command", "command", cmd.Command(), "connection_id", sess.id())
+
+	if err := s.writeConn(sess, msg); err != nil {
+		var ferr *events.FrameError
+		if errors.As(err, &ferr) {
+			// Nothing was written, only this command is affected
+			s.logger.Warn("refused to write malformed RCON command", "command", cmd.Command(), "error", err)
+			s.cmdWriter.CommandFailed(err)
+			return nil
+		}
+
+		return err
+	}
+
+	s.cmdWriter.CommandWritten()
+	return nil
+}
+
+// sendKeepAlive queues a keepalive command if nothing has been written in a while
+// and the previous keepalive has been answered
+func (s *Server) sendKeepAlive(sess *session) error {
+	if time.Since(time.Unix(0, sess.lastWrite.Load())) < s.keepAlive {
+		return nil
+	}
+
+	// Nothing reads the answer on the stream connection, so we write it ourselves
+	if !sess.role.commands() {
+		s.logger.Debug("stream connection idle, sending keepalive", "idle_for", s.keepAlive)
+		return s.writeConn(sess, commands.NewKeepAliveCommand().MarshalRCON())
+	}
+
+	if sess.keepAlive != nil {
+		select {
+		case <-sess.keepAlive.Done():
+		default:
+			return nil // Still waiting on the last one
+		}
+	}
+
+	// The writer must never wait for room in the queue, a full queue means we're far from idle anyway
+	pending, err := s.cmdWriter.TrySubmit(commands.NewKeepAliveCommand(), nil)
+	if err != nil {
+		s.logger.Debug("failed to queue keepalive", "error", err)
+		return nil
+	}
+
+	s.logger.Debug("connection idle, sending keepalive", "idle_for", s.keepAlive)
+	sess.keepAlive = pending
+	return nil
+}
+
+func (s *Server) writeConn(sess *session, msg []byte) error {
+	if s.writeTimeout > 0 {
+		sess.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+
+	if err := sess.enc.WriteFrame(msg); err != nil {
+		return fmt.Errorf("failed to write RCON msg at %s: %w", s.Address, err)
+	}
+
+	s.record(capture.Outbound, sess.id(), string(msg))
+	sess.lastWrite.Store(time.Now().UnixNano())
+	s.metrics.LineWritten(len(msg))
+	return nil
+}
+
+func (s *Server) readLoop(ctx context.Context, sess *session) error {
+	dec := events.NewDecoder(sess.conn, s.maxLineLength)
+
+	for {
+		if s.readTimeout > 0 {
+			sess.conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		}
+
+		msg, err := dec.Decode()
+
+		var ferr *events.FrameError
+		if errors.As(err, &ferr) {
+			if raw := dec.Raw(); raw != "" {
+				s.record(capture.Inbound, sess.id(), raw)
+				s.metrics.LineRead(len(raw))
+			}
+
+			// A single bad line isn't worth dropping the connection over
+			s.logger.Warn("discarded malformed RCON msg",
+				"error", err,
+				"max_line_length", s.maxLineLength,
+				"connection_id", sess.id(),
+			)
+			continue
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("failed to read RCON msg: %w", err)
+		}
+
+		raw := dec.Raw()
+		s.record(capture.Inbound, sess.id(), raw)
+		s.metrics.LineRead(len(raw))
+
+		if err := s.handleMsg(sess, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handleMsg(sess *session, msg events.Message) error {
+	switch msg.Type {
+	case events.RCONGameVersion:
+		var ver events.Version
+
+		if err := ver.Parse(msg.Body); err != nil {
+			// If we cant parse the version, we're gonna bomb out
+			// because we might run into unexpected behavior
+			return permanentError{err}
+		}
+
+		// Both connections of a split server talk to the same game server
+		if !sess.role.commands() {
+			return nil
+		}
+
+		game := games.GameUnknown
+		if ver.GameVersion > 12000 && ver.GameVersion < 13000 {
+			game = games.GameRenegadeX
+		}
+
+		s.infoMu.Lock()
+		s.info.version = ver
+		s.info.game = game
+		s.infoMu.Unlock()
+
+		s.logger.Info("connected to game server",
+			"rcon_version", ver.RCONVersion,
+			"game_version", ver.GameVersion,
+			"game", game.String(),
+		)
+	case events.AuthenticationSuccess:
+		sess.connectionID.Store(msg.Body)
+		if sess.role.commands() {
+			s.infoMu.Lock()
+			s.info.connectionID = msg.Body
+			s.infoMu.Unlock()
+		}
+		s.setLinkState(sess.role, StateAuthenticated)
+
+		select {
+		case <-sess.authenticated:
+			return nil // Already authenticated on this connection
+		default:
+			close(sess.authenticated)
+		}
+
+		if sess.onAuth != nil {
+			sess.onAuth()
+		}
+
+		// Authenticated and ready to accept streaming!
+		if err := s.applyStreamState(sess); err != nil {
+			return err
+		}
+	case events.Error:
+		var err events.ServerError
+		err.Parse(msg.Body)
+		s.metrics.ServerError(events.ServerError{ErrorMsg: s.secrets.redact(err.ErrorMsg), Kind: err.Kind})
+
+		// If we're not authenticated and we get an error, the server rejected us.
+		// Whether we try again is up to Retryable
+		select {
+		case <-sess.authenticated:
+		default:
+			return authError{addr: sess.addr, err: err}
+		}
+
+		// The game server answers lines in order, so an error while a written command is in flight
+		// is that command's and fails it once the `c` arrives. Anything else is just logged
+		if sess.role.commands() && s.cmdWriter.CommandError(err) {
+			s.logger.Debug("RCON command failed", "error", err, "connection_id", sess.id())
+			return nil
+		}
+
+		s.logger.Warn("RCON error", "error", err, "connection_id", sess.id())
+	case events.CommandResponse:
+		if sess.role.commands() {
+			s.cmdWriter.OnMsg(msg.Body)
+		}
+	case events.CommandExecutionFinished:
+		if sess.role.commands() {
+			s.logger.Debug("RCON command finished", "connection_id", sess.id())
+			s.cmdWriter.CommandDone()
+		}
+	case events.GameLog:
+		if !sess.role.stream() {
+			return nil
+		}
+
+		lm, err := events.ParseLog(msg.Body)
+		s.metrics.LogParsed(lm.Type, err)
+
+		s.events.publish(Event{
+			Kind:     EventGameLog,
+			Received: time.Now(),
+			Raw:      msg.Body,
+			Log:      lm,
+			Err:      err,
+		})
+	case events.ServerDevBot:
+		// The game server sends DevBot messages to every connection, only report them once
+		if !sess.role.stream() {
+			return nil
+		}
+		var dm events.DevBotMessage
+		err := dm.Parse(msg.Body)
+
+		s.events.publish(Event{
+			Kind:     EventDevBot,
+			Received: time.Now(),
+			Raw:      msg.Body,
+			DevBot:   dm,
+			Err:      err,
+		})
+	}
+
+	return nil
+}
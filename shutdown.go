@@ -0,0 +1,114 @@
+package rcon
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+// ErrShutdown is returned for commands that couldn't be sent because the server is shutting down,
+// and by Start once Shutdown was called
+var ErrShutdown = errors.New("rcon: server shut down")
+
+// ErrAlreadyRunning is returned by Start while another call to Start is still running
+var ErrAlreadyRunning = errors.New("rcon: server already running")
+
+// Shutdown gracefully stops the server.
+//
+// It stops accepting new commands and waits for the ones already queued to finish. Whatever
+// hasn't finished by the time ctx or the shutdown timeout expires fails with ErrShutdown.
+// It then unsubscribes from the event stream, closes the connection and waits for Start and
+// the GameStateManager to return. ctx's error is returned if the commands couldn't be drained in time.
+//
+// A server can't be started again once it was shut down
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.shutdownTimeout)
+		defer cancel()
+	}
+
+	s.shutdown.Store(true)
+	drained := s.cmdWriter.Close(ErrShutdown)
+
+	s.runMu.Lock()
+	stop, stopped := s.stop, s.stopped
+	s.runMu.Unlock()
+
+	s.logger.Info("shutting down RCON link", "queued", s.cmdWriter.Len())
+
+	var err error
+	if stopped != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	// Whatever couldn't be sent in time won't be anymore, including a command still in flight
+	s.cmdWriter.FailQueued(ErrShutdown)
+	s.cmdWriter.CommandFailed(ErrShutdown)
+
+	if stopped == nil {
+		return err // Not running
+	}
+
+	role := s.streamRole()
+	if sess := s.linkSession(role).Load(); sess != nil && s.linkState(role) == StateStreaming {
+		// Flushed by the writer before the connection is closed
+		sess.write([]byte{byte(events.UnSubscribe), events.NewLine})
+	}
+
+	stop()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// Closing the connections unblocks a write stuck on an unresponsive server
+		for _, sess := range []*session{s.sess.Load(), s.streamSess.Load()} {
+			if sess != nil {
+				sess.conn.Close()
+			}
+		}
+
+		<-stopped
+		err = ctx.Err()
+	}
+
+	return err
+}
+
+// running marks the server as started, the returned context is cancelled by Shutdown
+func (s *Server) running(ctx context.Context) (context.Context, context.CancelFunc, chan struct{}, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if s.shutdown.Load() {
+		return nil, nil, nil, ErrShutdown
+	}
+
+	// Two loops would fight over the connection and the Dispatcher
+	if s.stopped != nil {
+		return nil, nil, nil, ErrAlreadyRunning
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.stop = cancel
+	s.stopped = make(chan struct{})
+
+	return ctx, cancel, s.stopped, nil
+}
+
+// stopRunning marks the server as stopped once Start returns
+func (s *Server) stopRunning(stopped chan struct{}) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if s.stopped == stopped {
+		s.stop, s.stopped = nil, nil
+	}
+
+	close(stopped)
+}
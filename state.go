@@ -2,8 +2,7 @@ package rcon
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/tankbusta/renx-rcon/commands"
@@ -13,7 +12,9 @@ import (
 var cmdUpdateBotState = commands.NewListBotsCommand()
 
 type IServer interface {
-	WriteMsg(msg commands.ICommand, cb commands.HandleCommandResp)
+	WriteMsg(msg commands.ICommand, cb commands.HandleCommandResp) error
+	WriteMsgContext(ctx context.Context, msg commands.ICommand, cb commands.HandleCommandResp) error
+	WriteMsgWithDone(ctx context.Context, msg commands.ICommand, cb commands.HandleCommandResp, done commands.HandleCommandDone) error
 	Ready() bool
 }
 
@@ -30,40 +31,81 @@ type GameStateManager struct {
 	LastUpdated time.Time
 
 	// unexported fields below
-	parent IServer
+	parent       IServer
+	logger       *slog.Logger
+	pollInterval time.Duration
 }
 
 func NewGameState(parent IServer) *GameStateManager {
 	gsm := &GameStateManager{
 		Players: make(state.Players, 0), // While players are mostly limited to 64, server admins might go crazy
 		parent:  parent,
+		logger:  discardLogger,
+
+		pollInterval: DefaultStatePollInterval,
 	}
 
 	return gsm
 }
 
+// SetLogger sets where the state manager logs to. Nothing is logged unless a logger is set
+func (s *GameStateManager) SetLogger(l *slog.Logger) {
+	s.logger = loggerOrDiscard(l)
+}
+
+// SetPollInterval changes how often the state is refreshed, zero disables polling. It must be called before Start
+func (s *GameStateManager) SetPollInterval(d time.Duration) {
+	s.pollInterval = d
+}
+
 func (s *GameStateManager) onBotStateUpdate(cmd commands.ICommand, data string) {
 	var p state.Player
 
 	if err := cmd.UnmarshalRCON(data, &p); err != nil {
-		log.Printf("[ !! ] Failed to unmarshal bot state: %s", err)
+		s.logger.Warn("failed to unmarshal bot state", "error", err)
 		return
 	}
 
-	log.Println("[ !! ] Received bot state update")
-	fmt.Println(p)
+	s.logger.Debug("received bot state update",
+		"player_id", p.ID,
+		"player", p.Name,
+		"team", p.Team.String(),
+		"score", p.Score,
+	)
+}
+
+func (s *GameStateManager) onStateCheckDone(cmd commands.ICommand, err error) {
+	if err != nil {
+		s.logger.Warn("state check failed", "command", cmd.Command(), "error", err)
+	}
+}
+
+// handlerFor returns the callback the state manager handles the responses to cmd with, if any
+func (s *GameStateManager) handlerFor(cmd commands.ICommand) commands.HandleCommandResp {
+	if cmd.Command() == cmdUpdateBotState.Command() {
+		return s.onBotStateUpdate
+	}
+
+	return nil
 }
 
 // dispatchStateCheck sends several messages to the server to verify the game state matches
-func (s *GameStateManager) dispatchStateCheck() error {
-	s.parent.WriteMsg(cmdUpdateBotState, s.onBotStateUpdate)
+func (s *GameStateManager) dispatchStateCheck(ctx context.Context) error {
+	if err := s.parent.WriteMsgWithDone(ctx, cmdUpdateBotState, s.onBotStateUpdate, s.onStateCheckDone); err != nil {
+		return err
+	}
 
 	s.LastUpdated = time.Now()
 	return nil
 }
 
 func (s *GameStateManager) Start(ctx context.Context) {
-	ticker := time.NewTicker(time.Second * 5)
+	if s.pollInterval <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(s.pollInterval)
 	defer ticker.Stop()
 
 StateLoop:
@@ -72,12 +114,14 @@ StateLoop:
 		case <-ctx.Done():
 			break StateLoop
 		case <-ticker.C:
-			log.Println("[ !! ] Dispatching state check")
+			s.logger.Debug("dispatching state check")
 
-			// We need to send a message to RCON at least once every 60 seconds otherwise the game server will disconnect us
-			// So let's take this opportunity to update our state!
+			// The Server's keepalive takes care of the 60 second idle disconnect,
+			// we only poll to keep our state accurate
 			if s.parent.Ready() {
-				s.dispatchStateCheck()
+				if err := s.dispatchStateCheck(ctx); err != nil {
+					s.logger.Warn("failed to dispatch state check", "error", err)
+				}
 			}
 		}
 	}
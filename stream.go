@@ -0,0 +1,79 @@
+package rcon
+
+import (
+	"fmt"
+
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+// SubscribeStream starts the game server's event stream.
+//
+// If we're not authenticated yet the subscription is sent as soon as we are,
+// and it's renewed every time the connection is re-established
+func (s *Server) SubscribeStream() error {
+	return s.setStreaming(true)
+}
+
+// UnsubscribeStream stops the game server's event stream while keeping the connection
+// open for commands. The stream stays off across reconnects until SubscribeStream is called
+func (s *Server) UnsubscribeStream() error {
+	return s.setStreaming(false)
+}
+
+func (s *Server) setStreaming(enabled bool) error {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	if s.streamEvents == enabled {
+		return nil
+	}
+	s.streamEvents = enabled
+
+	role := s.streamRole()
+
+	sess := s.linkSession(role).Load()
+	if sess == nil {
+		return nil // Applied once we've authenticated
+	}
+
+	switch s.linkState(role) {
+	case StateAuthenticated, StateStreaming:
+	default:
+		return nil
+	}
+
+	return s.writeStreamState(sess, enabled)
+}
+
+// applyStreamState sends our desired stream subscription on a freshly authenticated session
+func (s *Server) applyStreamState(sess *session) error {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	if sess.role == linkCommands {
+		s.logger.Info("authenticated, command connection ready", "connection_id", sess.id())
+		return nil
+	}
+
+	if !s.streamEvents {
+		s.logger.Info("authenticated, command-only session", "connection_id", sess.id())
+		return nil
+	}
+
+	s.logger.Info("authenticated, starting event stream", "connection_id", sess.id())
+	return s.writeStreamState(sess, true)
+}
+
+func (s *Server) writeStreamState(sess *session, enabled bool) error {
+	msgType, to := events.UnSubscribe, StateAuthenticated
+	if enabled {
+		msgType, to = events.Subscribe, StateStreaming
+	}
+
+	if err := sess.write([]byte{byte(msgType), events.NewLine}); err != nil {
+		return fmt.Errorf("failed to change RCON event stream subscription: %w", err)
+	}
+
+	s.setLinkState(sess.role, to)
+	return nil
+}
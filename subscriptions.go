@@ -0,0 +1,184 @@
+package rcon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tankbusta/renx-rcon/events"
+)
+
+// EventQueueSize is how many events a callback subscriber may fall behind before events are dropped
+const EventQueueSize = 256
+
+// EventKind identifies which RCON stream an Event came from
+type EventKind uint8
+
+const (
+	// EventGameLog is a `l` line from the game server's event stream
+	EventGameLog EventKind = iota
+
+	// EventDevBot is a `d` line relayed over the DevBot channel
+	EventDevBot
+)
+
+func (s EventKind) String() string {
+	switch s {
+	case EventGameLog:
+		return "GameLog"
+	case EventDevBot:
+		return "DevBot"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event is a single message received from the game server's event stream
+type Event struct {
+	Kind EventKind
+
+	// Received is the time we read the message off the wire
+	Received time.Time
+
+	// Raw is the message body as sent by the game server, minus the type and trailing newline
+	Raw string
+
+	// Log is the parsed message when Kind is EventGameLog
+	Log events.LogMessage
+
+	// DevBot is the parsed message when Kind is EventDevBot
+	DevBot events.DevBotMessage
+
+	// Err is set if Raw could not be parsed
+	Err error
+}
+
+// EventFilter decides if an Event should be delivered to a subscriber
+type EventFilter func(Event) bool
+
+// FilterLog matches game logs of the given type and activity.
+// An empty typ or activity matches any value
+func FilterLog(typ events.LogType, activity events.LogActivity) EventFilter {
+	return func(ev Event) bool {
+		if ev.Kind != EventGameLog || ev.Err != nil {
+			return false
+		}
+
+		return (typ == "" || ev.Log.Type == typ) && (activity == "" || ev.Log.Activity == activity)
+	}
+}
+
+// FilterDevBot matches every message received over the DevBot channel
+func FilterDevBot() EventFilter {
+	return func(ev Event) bool {
+		return ev.Kind == EventDevBot
+	}
+}
+
+type subscriber[T any] struct {
+	ch      chan T
+	filters []func(T) bool
+}
+
+func (s *subscriber[T]) wants(ev T) bool {
+	if len(s.filters) == 0 {
+		return true
+	}
+
+	for _, filter := range s.filters {
+		if filter(ev) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// callback runs fn for every event delivered to sub until it's unsubscribed
+func (s *subscriber[T]) callback(fn func(T)) {
+	go func() {
+		for ev := range s.ch {
+			fn(ev)
+		}
+	}()
+}
+
+// eventHub fans events out to every subscriber.
+//
+// Publishing never blocks: a subscriber that can't keep up loses events instead of stalling
+// the RCON reader, which would otherwise delay command responses
+type eventHub[T any] struct {
+	mu   sync.RWMutex
+	subs map[*subscriber[T]]struct{}
+}
+
+func newEventHub[T any]() *eventHub[T] {
+	return &eventHub[T]{
+		subs: make(map[*subscriber[T]]struct{}),
+	}
+}
+
+func (s *eventHub[T]) subscribe(buffer int, filters []func(T) bool) (*subscriber[T], func()) {
+	sub := &subscriber[T]{
+		ch:      make(chan T, buffer),
+		filters: filters,
+	}
+
+	s.mu.Lock()
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, sub)
+			close(sub.ch)
+			s.mu.Unlock()
+		})
+	}
+}
+
+func (s *eventHub[T]) publish(ev T) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for sub := range s.subs {
+		if !sub.wants(ev) {
+			continue
+		}
+
+		select {
+		case sub.ch <- ev:
+		default: // Subscriber is falling behind
+		}
+	}
+}
+
+func eventFilters(filters []EventFilter) []func(Event) bool {
+	out := make([]func(Event) bool, len(filters))
+	for i, filter := range filters {
+		out[i] = filter
+	}
+
+	return out
+}
+
+// OnEvent calls fn for every event matching any of filters, or every event if none are given.
+//
+// fn is called from a dedicated goroutine, one event at a time in the order they were received.
+// Call the returned function to unsubscribe
+func (s *Server) OnEvent(fn func(Event), filters ...EventFilter) (unsubscribe func()) {
+	sub, unsubscribe := s.events.subscribe(EventQueueSize, eventFilters(filters))
+	sub.callback(fn)
+
+	return unsubscribe
+}
+
+// Events returns a channel receiving every event matching any of filters, or every event if none are given.
+//
+// Events are dropped if the channel's buffer is full. Call the returned function to unsubscribe,
+// which also closes the channel
+func (s *Server) Events(buffer int, filters ...EventFilter) (<-chan Event, func()) {
+	sub, unsubscribe := s.events.subscribe(buffer, eventFilters(filters))
+	return sub.ch, unsubscribe
+}